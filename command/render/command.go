@@ -0,0 +1,287 @@
+// Package render implements the render command, which renders promtail's
+// config from manifests on disk without access to a cluster. The snippets of
+// the pods and workloads of the manifests are resolved, checked and rendered
+// the same way the operator does, so the config can be reviewed, e.g. in CI,
+// before the manifests are applied.
+package render
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/giantswarm/loki-operator/service/controller"
+	"github.com/giantswarm/loki-operator/service/controller/baseconfig"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+	"github.com/giantswarm/loki-operator/service/controller/tenant"
+)
+
+type Config struct {
+	// Stdout receives promtail's config.
+	Stdout io.Writer
+	// Stderr receives the problems found in the manifests.
+	Stderr io.Writer
+}
+
+// Command renders promtail's config from the manifests in a directory.
+type Command struct {
+	// Dependencies.
+	stdout io.Writer
+	stderr io.Writer
+
+	// Internals.
+	cobraCommand *cobra.Command
+
+	// Settings.
+	allowedLabels        []string
+	baseConfig           string
+	defaultTenant        string
+	deniedLabels         []string
+	ignoredLabels        []string
+	mandatoryStages      string
+	maxLabels            int
+	namespace            string
+	namespaceSelector    string
+	namespaces           []string
+	privilegedNamespaces []string
+	tenantMapping        []string
+}
+
+func New(config Config) (*Command, error) {
+	if config.Stdout == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Stdout must not be empty", config)
+	}
+	if config.Stderr == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Stderr must not be empty", config)
+	}
+
+	c := &Command{
+		stdout: config.Stdout,
+		stderr: config.Stderr,
+	}
+
+	c.cobraCommand = &cobra.Command{
+		Use:   "render DIR",
+		Short: "Render promtail's config from the manifests in a directory.",
+		Long: "Render promtail's config from the Pods, workloads, snippet ConfigMaps and Namespaces of the manifests " +
+			"in a directory, without access to a cluster. Problems are reported on stderr, any rejected or missing " +
+			"snippet makes the command fail.",
+		Args: cobra.ExactArgs(1),
+		Run:  c.execute,
+	}
+
+	flags := c.cobraCommand.Flags()
+	flags.StringVar(&c.baseConfig, "base-config", "", "File holding the base of promtail's config the scrape configs are merged into, the default base is used when empty")
+	flags.StringVar(&c.mandatoryStages, "mandatory-stages", "", "File holding the stages put at the start (prepend) and at the end (append) of every job")
+	flags.StringVar(&c.namespace, "namespace", "default", "Namespace of the objects whose manifests don't have one")
+	flags.StringSliceVar(&c.namespaces, "namespaces", nil, "Namespaces whose pods are rendered, all namespaces are rendered when empty")
+	flags.StringVar(&c.namespaceSelector, "namespace-selector", "", "Label selector restricting the namespaces whose pods are rendered")
+	flags.StringSliceVar(&c.ignoredLabels, "ignored-labels", promtailconfig.DefaultIgnoredLabels, "Pod labels never used to select the pods of a promtail job")
+	flags.StringSliceVar(&c.privilegedNamespaces, "privileged-namespaces", nil, "Namespaces whose snippets may tail any files, e.g. with static_configs or journal jobs, and discover pods of other namespaces")
+	flags.StringSliceVar(&c.allowedLabels, "allowed-labels", nil, "Only label names the labels stages of snippets may promote, all label names are allowed when empty")
+	flags.StringSliceVar(&c.deniedLabels, "denied-labels", promtailconfig.DefaultDeniedLabels, "Label names the labels stages of snippets must not promote")
+	flags.IntVar(&c.maxLabels, "max-labels", promtailconfig.DefaultMaxLabels, "Maximum number of labels promoted by the labels stages of a job, not limited when 0")
+	flags.StringVar(&c.defaultTenant, "default-tenant", "", "Loki tenant of namespaces neither annotated nor mapped, no tenant stages are rendered when empty")
+	flags.StringSliceVar(&c.tenantMapping, "tenant-mapping", nil, "Loki tenants of namespaces not annotated, as namespace=tenant entries")
+
+	return c, nil
+}
+
+func (c *Command) CobraCommand() *cobra.Command {
+	return c.cobraCommand
+}
+
+func (c *Command) execute(cmd *cobra.Command, args []string) {
+	err := c.render(args[0])
+	if err != nil {
+		fmt.Fprintf(c.stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// render prints promtail's config rendered from the manifests in dir. The
+// config is printed even when snippets were rejected or are missing, it is
+// rendered without them then.
+func (c *Command) render(dir string) error {
+	renderer, err := c.newRenderer()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	tenantMapping, err := tenant.NewMapping(tenant.MappingConfig{
+		Default: c.defaultTenant,
+		Mapping: c.tenantMapping,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	filter, err := namespacescope.NewFilter(namespacescope.FilterConfig{
+		Namespaces:    c.namespaces,
+		LabelSelector: c.namespaceSelector,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	m, err := readManifests(dir, c.namespace)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if tenantMapping.Enabled() {
+		c.warnInvalidTenants(tenantMapping, m.namespaces)
+		renderer.SetTenantResolver(&tenantResolver{
+			mapping:   tenantMapping,
+			manifests: m,
+		})
+	}
+
+	keyResolver, err := promtailconfig.NewKeyResolver(promtailconfig.KeyResolverConfig{
+		OwnerGetter:   m,
+		IgnoredLabels: c.ignoredLabels,
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	registry := promtailconfig.NewRegistry()
+	var failed int
+	for _, pod := range m.pods {
+		if _, found := pod.Labels[podconfig.PromtailConfigLabel]; !found {
+			continue
+		}
+		err = filter.Check(m.Namespace(pod.Namespace))
+		if namespacescope.IsExcludedNamespace(err) {
+			c.infof("%s: skipped, %s", pod.UID, err.Error())
+			continue
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+
+		configs, err := podconfig.ContainerConfigs(keyResolver, pod)
+		if podconfig.IsInvalidDynamicConfig(err) {
+			c.warnf("%s: %s", pod.UID, err.Error())
+			failed++
+			continue
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+
+		for _, config := range configs {
+			content, err := m.LoadSnippet(pod.Namespace, config)
+			if IsInvalidManifest(err) {
+				c.warnf("%s: snippet of container %#q not loaded: %s", pod.UID, config.Key.ContainerName, err.Error())
+				failed++
+				continue
+			} else if err != nil {
+				return microerror.Mask(err)
+			}
+
+			canonical, err := renderer.Accept(config.Key, content)
+			if promtailconfig.IsInvalidSnippet(err) {
+				c.warnf("%s: snippet of container %#q rejected: %s", pod.UID, config.Key.ContainerName, err.Error())
+				failed++
+				continue
+			} else if err != nil {
+				return microerror.Mask(err)
+			}
+
+			err = registry.Register(config.Key, pod.UID, canonical)
+			if promtailconfig.IsSnippetConflict(err) {
+				// As in the cluster, the snippet registered last is
+				// rendered.
+				c.warnf("%s: snippet of container %#q differs from the ones of other manifests of the workload, "+
+					"the snippet read last is rendered", pod.UID, config.Key.ContainerName)
+			} else if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+	}
+
+	config, err := renderer.Render(registry.Snippets())
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	fmt.Fprint(c.stdout, config)
+
+	if failed > 0 {
+		return microerror.Maskf(renderFailedError, "%d snippets or pods rejected", failed)
+	}
+
+	return nil
+}
+
+// newRenderer returns a Renderer configured by the settings of the command.
+func (c *Command) newRenderer() (*promtailconfig.Renderer, error) {
+	renderer := promtailconfig.NewRenderer()
+
+	if c.baseConfig != "" {
+		b, err := ioutil.ReadFile(c.baseConfig)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		base, err := baseconfig.Parse(string(b))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		renderer.SetBase(base)
+	}
+
+	if c.mandatoryStages != "" {
+		b, err := ioutil.ReadFile(c.mandatoryStages)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		mandatory, err := baseconfig.ParseMandatoryStages(string(b))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		renderer.SetMandatoryStages(mandatory)
+	}
+
+	policy, err := controller.NewPolicy(controller.LokiOperatorConfig{
+		PrivilegedNamespaces: c.privilegedNamespaces,
+		AllowedLabels:        c.allowedLabels,
+		DeniedLabels:         c.deniedLabels,
+		MaxLabels:            c.maxLabels,
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	renderer.SetPolicy(policy)
+
+	return renderer, nil
+}
+
+// warnInvalidTenants warns about the tenant annotations of namespaces which
+// are ignored, like the operator does.
+func (c *Command) warnInvalidTenants(mapping *tenant.Mapping, namespaces map[string]*v1.Namespace) {
+	var names []string
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, err := mapping.Tenant(namespaces[name])
+		if err != nil {
+			c.warnf("namespace %s: ignoring %#q annotation: %s", name, tenant.Annotation, err.Error())
+		}
+	}
+}
+
+func (c *Command) infof(format string, args ...interface{}) {
+	fmt.Fprintf(c.stderr, "Info: %s\n", fmt.Sprintf(format, args...))
+}
+
+func (c *Command) warnf(format string, args ...interface{}) {
+	fmt.Fprintf(c.stderr, "Warning: %s\n", fmt.Sprintf(format, args...))
+}
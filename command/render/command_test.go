@@ -0,0 +1,170 @@
+package render
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/giantswarm/k8sclient"
+	"github.com/giantswarm/micrologger/microloggertest"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/giantswarm/loki-operator/service/controller"
+	"github.com/giantswarm/loki-operator/service/controller/baseconfig"
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+// The clients and the handler are needed to create the operator's renderer,
+// they are never called by rendering.
+type (
+	fakeK8sClient  struct{ k8sclient.Interface }
+	fakeKubernetes struct{ kubernetes.Interface }
+	fakeHandler    struct{ promtailconfig.Handler }
+)
+
+var (
+	fixturesDir        = filepath.Join("testdata", "manifests")
+	baseConfigFile     = filepath.Join("testdata", "base.yaml")
+	mandatoryStageFile = filepath.Join("testdata", "mandatory-stages.yaml")
+
+	keyWeb = promtailconfig.Key{Namespace: "team", Workload: "Deployment/web", Labels: "app=web", ContainerName: "nginx"}
+	keyAPI = promtailconfig.Key{Namespace: "team", Workload: "Pod/api",
+		Labels: "app=api,giantswarm.io/loki-promtail-config=api-promtail", ContainerName: "api"}
+	keyDB = promtailconfig.Key{Namespace: "other", Workload: "StatefulSet/db", Labels: "app=db", ContainerName: "postgres"}
+)
+
+// operatorConfig renders snippets the way the operator does, with the base
+// config, the mandatory stages and the policy set up like in the cluster.
+func operatorConfig(t *testing.T, c *Command, snippets map[promtailconfig.Key]string) string {
+	t.Helper()
+
+	pc, err := controller.NewPromtailConfigMap(fakeK8sClient{}, controller.LokiOperatorConfig{
+		PromtailConfigmapNamespace: "loki",
+		PromtailConfigmapName:      "promtail",
+		PrivilegedNamespaces:       c.privilegedNamespaces,
+		AllowedLabels:              c.allowedLabels,
+		DeniedLabels:               c.deniedLabels,
+		MaxLabels:                  c.maxLabels,
+	})
+	if err != nil {
+		t.Fatalf("expected no error creating promtail's ConfigMap, got %#q", err)
+	}
+
+	base, err := ioutil.ReadFile(c.baseConfig)
+	if err != nil {
+		t.Fatalf("expected no error reading the base config, got %#q", err)
+	}
+	mandatory, err := ioutil.ReadFile(c.mandatoryStages)
+	if err != nil {
+		t.Fatalf("expected no error reading the mandatory stages, got %#q", err)
+	}
+	_, err = baseconfig.New(baseconfig.Config{
+		K8sClient:         fakeKubernetes{},
+		Logger:            microloggertest.New(),
+		Handler:           fakeHandler{},
+		PromtailConfigMap: pc,
+
+		Inline:                string(base),
+		MandatoryStagesInline: string(mandatory),
+	})
+	if err != nil {
+		t.Fatalf("expected no error loading the base config, got %#q", err)
+	}
+
+	fixtures, err := readManifests(fixturesDir, c.namespace)
+	if err != nil {
+		t.Fatalf("expected no error reading the fixtures, got %#q", err)
+	}
+	accepted := map[promtailconfig.Key]string{}
+	for key, configMapName := range snippets {
+		content, err := fixtures.LoadSnippet(key.Namespace, podconfig.ContainerConfig{
+			ConfigMapName: configMapName,
+			ConfigMapKey:  podconfig.PromtailConfigMapKeyName,
+		})
+		if err != nil {
+			t.Fatalf("expected no error loading the snippet of %#v, got %#q", key, err)
+		}
+		accepted[key], err = pc.Accept(key, content)
+		if err != nil {
+			t.Fatalf("expected the operator to accept the snippet of %#v, got %#q", key, err)
+		}
+	}
+
+	config, err := pc.Render(accepted)
+	if err != nil {
+		t.Fatalf("expected no error rendering the operator's config, got %#q", err)
+	}
+
+	return config
+}
+
+// Test_Command_render ensures the render command renders the config the
+// operator renders for the pods of the included namespaces.
+func Test_Command_render(t *testing.T) {
+	testCases := []struct {
+		name              string
+		namespaces        []string
+		namespaceSelector string
+		// expected maps the Keys expected to be rendered to the names of
+		// the ConfigMaps holding their snippets.
+		expected map[promtailconfig.Key]string
+	}{
+		{
+			name: "case 0: all namespaces but the disabled one",
+			expected: map[promtailconfig.Key]string{
+				keyWeb: "web-promtail",
+				keyAPI: "api-promtail",
+				keyDB:  "db-promtail",
+			},
+		},
+		{
+			name:              "case 1: namespaces matching the selector",
+			namespaceSelector: "logging=enabled",
+			expected: map[promtailconfig.Key]string{
+				keyWeb: "web-promtail",
+				keyAPI: "api-promtail",
+			},
+		},
+		{
+			name:       "case 2: listed namespaces",
+			namespaces: []string{"other", "disabled"},
+			expected: map[promtailconfig.Key]string{
+				keyDB: "db-promtail",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			c, err := New(Config{
+				Stdout: &stdout,
+				Stderr: &stderr,
+			})
+			if err != nil {
+				t.Fatalf("expected no error creating the command, got %#q", err)
+			}
+			err = c.CobraCommand().Flags().Parse([]string{
+				"--base-config", baseConfigFile,
+				"--mandatory-stages", mandatoryStageFile,
+			})
+			if err != nil {
+				t.Fatalf("expected no error parsing the flags, got %#q", err)
+			}
+			c.namespaces = tc.namespaces
+			c.namespaceSelector = tc.namespaceSelector
+
+			err = c.render(fixturesDir)
+			if err != nil {
+				t.Fatalf("expected no error, got %#q\n%s", err, stderr.String())
+			}
+
+			expected := operatorConfig(t, c, tc.expected)
+			if stdout.String() != expected {
+				t.Fatalf("expected the operator's config\n%s\ngot\n%s", expected, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package render
+
+import (
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
+
+var invalidManifestError = &microerror.Error{
+	Kind: "invalidManifestError",
+}
+
+// IsInvalidManifest asserts invalidManifestError.
+func IsInvalidManifest(err error) bool {
+	return microerror.Cause(err) == invalidManifestError
+}
+
+var renderFailedError = &microerror.Error{
+	Kind: "renderFailedError",
+}
+
+// IsRenderFailed asserts renderFailedError.
+func IsRenderFailed(err error) bool {
+	return microerror.Cause(err) == renderFailedError
+}
@@ -0,0 +1,240 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+)
+
+// manifestExtensions are the extensions of the files manifests are read from.
+var manifestExtensions = map[string]bool{
+	".json": true,
+	".yaml": true,
+	".yml":  true,
+}
+
+// manifests holds the objects read from the manifests in a directory. It
+// implements promtailconfig.OwnerGetter, looking up the owners of pods among
+// the workloads of the manifests.
+type manifests struct {
+	// namespace is the namespace of the objects whose manifests don't have
+	// one.
+	namespace string
+
+	// pods holds the pods of the manifests and the pods created from the pod
+	// templates of the workloads, in the order they were read. The UID of
+	// every pod identifies the manifest it comes from.
+	pods       []*v1.Pod
+	owners     map[string]owner
+	configMaps map[string]*v1.ConfigMap
+	namespaces map[string]*v1.Namespace
+}
+
+// owner is a workload able to own pods.
+type owner struct {
+	object   metav1.Object
+	selector *metav1.LabelSelector
+}
+
+// readManifests reads the manifests of all the files in dir and its
+// subdirectories. Files may hold multiple YAML documents and List objects.
+// Objects of kinds unknown to Kubernetes, like custom resources, are skipped.
+func readManifests(dir, namespace string) (*manifests, error) {
+	m := &manifests{
+		namespace: namespace,
+
+		owners:     map[string]owner{},
+		configMaps: map[string]*v1.ConfigMap{},
+		namespaces: map[string]*v1.Namespace{},
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		if info.IsDir() || !manifestExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		return m.readFile(path)
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return m, nil
+}
+
+func (m *manifests) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer f.Close()
+
+	reader := yaml.NewYAMLReader(bufio.NewReader(f))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return microerror.Maskf(invalidManifestError, "%s: %v", path, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		err = m.add(doc)
+		if err != nil {
+			return microerror.Maskf(invalidManifestError, "%s: document %d: %v", path, i, err)
+		}
+	}
+}
+
+// add adds the object decoded from doc.
+func (m *manifests) add(doc []byte) error {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+	if runtime.IsNotRegisteredError(err) || runtime.IsMissingKind(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch o := obj.(type) {
+	case *v1.List:
+		for _, item := range o.Items {
+			err := m.add(item.Raw)
+			if err != nil {
+				return err
+			}
+		}
+	case *v1.Pod:
+		m.setNamespace(&o.ObjectMeta)
+		o.UID = types.UID(ownerKey(o.Namespace, "Pod", o.Name))
+		m.pods = append(m.pods, o)
+	case *v1.ConfigMap:
+		m.setNamespace(&o.ObjectMeta)
+		m.configMaps[configMapKey(o.Namespace, o.Name)] = o
+	case *v1.Namespace:
+		m.namespaces[o.Name] = o
+	case *appsv1.Deployment:
+		m.addWorkload("Deployment", o, o.Spec.Selector, o.Spec.Template)
+	case *appsv1.StatefulSet:
+		m.addWorkload("StatefulSet", o, o.Spec.Selector, o.Spec.Template)
+	case *appsv1.DaemonSet:
+		m.addWorkload("DaemonSet", o, o.Spec.Selector, o.Spec.Template)
+	case *appsv1.ReplicaSet:
+		m.addWorkload("ReplicaSet", o, o.Spec.Selector, o.Spec.Template)
+	case *batchv1.Job:
+		m.addWorkload("Job", o, o.Spec.Selector, o.Spec.Template)
+	case *batchv1beta1.CronJob:
+		template := o.Spec.JobTemplate.Spec.Template
+		m.addWorkload("CronJob", o, &metav1.LabelSelector{MatchLabels: template.Labels}, template)
+	}
+
+	return nil
+}
+
+// addWorkload adds a workload and a pod created from its pod template, the
+// way the pods of the workload look in the cluster. The pod is owned by the
+// workload directly, so intermediate owners, e.g. the ReplicaSets of
+// Deployments, don't need manifests.
+func (m *manifests) addWorkload(kind string, workload metav1.Object, selector *metav1.LabelSelector, template v1.PodTemplateSpec) {
+	if workload.GetNamespace() == "" {
+		workload.SetNamespace(m.namespace)
+	}
+	m.owners[ownerKey(workload.GetNamespace(), kind, workload.GetName())] = owner{
+		object:   workload,
+		selector: selector,
+	}
+
+	controller := true
+	pod := &v1.Pod{
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	pod.Name = workload.GetName()
+	pod.Namespace = workload.GetNamespace()
+	pod.UID = types.UID(ownerKey(pod.Namespace, kind, pod.Name))
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{
+			Kind:       kind,
+			Name:       workload.GetName(),
+			Controller: &controller,
+		},
+	}
+
+	m.pods = append(m.pods, pod)
+}
+
+func (m *manifests) setNamespace(meta *metav1.ObjectMeta) {
+	if meta.Namespace == "" {
+		meta.Namespace = m.namespace
+	}
+}
+
+// Namespace returns the Namespace named name from the manifests. Namespaces
+// without manifest have neither labels nor annotations.
+func (m *manifests) Namespace(name string) *v1.Namespace {
+	ns, found := m.namespaces[name]
+	if !found {
+		ns = &v1.Namespace{}
+		ns.Name = name
+	}
+
+	return ns
+}
+
+// GetOwner returns the workload referenced by ref in namespace. Owners without
+// manifest are not found, as if they were being deleted.
+func (m *manifests) GetOwner(namespace string, ref metav1.OwnerReference) (metav1.Object, *metav1.LabelSelector, error) {
+	o, found := m.owners[ownerKey(namespace, ref.Kind, ref.Name)]
+	if !found {
+		return nil, nil, microerror.Mask(errors.NewNotFound(schema.GroupResource{Resource: strings.ToLower(ref.Kind)}, ref.Name))
+	}
+
+	return o.object, o.selector, nil
+}
+
+// LoadSnippet returns the snippet of config from its ConfigMap in namespace.
+func (m *manifests) LoadSnippet(namespace string, config podconfig.ContainerConfig) (string, error) {
+	cm, found := m.configMaps[configMapKey(namespace, config.ConfigMapName)]
+	if !found {
+		return "", microerror.Maskf(invalidManifestError, "ConfigMap %s/%s not found in the manifests",
+			namespace, config.ConfigMapName)
+	}
+	content, found := cm.Data[config.ConfigMapKey]
+	if !found {
+		return "", microerror.Maskf(invalidManifestError, "%#q key not found in ConfigMap %s/%s",
+			config.ConfigMapKey, namespace, config.ConfigMapName)
+	}
+
+	return content, nil
+}
+
+func configMapKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+func ownerKey(namespace, kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
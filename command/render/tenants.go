@@ -0,0 +1,25 @@
+package render
+
+import (
+	"github.com/giantswarm/loki-operator/service/controller/tenant"
+)
+
+// tenantResolver tells the tenants of namespaces from the Namespaces of the
+// manifests, the way tenant.Resolver does from the ones of the cluster. It
+// implements promtailconfig.TenantResolver.
+type tenantResolver struct {
+	mapping   *tenant.Mapping
+	manifests *manifests
+}
+
+// Tenant returns the tenant of namespace, or an empty string when tenants are
+// disabled. Namespaces without manifest get the tenant of the mapping table
+// or the default tenant.
+func (t *tenantResolver) Tenant(namespace string) string {
+	if !t.mapping.Enabled() {
+		return ""
+	}
+
+	tenant, _ := t.mapping.Tenant(t.manifests.Namespace(namespace))
+	return tenant
+}
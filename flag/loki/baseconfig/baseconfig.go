@@ -0,0 +1,11 @@
+package baseconfig
+
+type BaseConfig struct {
+	Inline    string
+	Namespace string
+	Name      string
+	Key       string
+
+	MandatoryStages    string
+	MandatoryStagesKey string
+}
@@ -0,0 +1,7 @@
+package labels
+
+type Labels struct {
+	Allowed string
+	Denied  string
+	Max     string
+}
@@ -1,8 +1,34 @@
 package loki
 
+import (
+	"github.com/giantswarm/loki-operator/flag/loki/baseconfig"
+	"github.com/giantswarm/loki-operator/flag/loki/labels"
+	"github.com/giantswarm/loki-operator/flag/loki/leaderelection"
+	"github.com/giantswarm/loki-operator/flag/loki/tenants"
+)
+
 type Loki struct {
-	Namespace       string
-	Name            string
-	InitialDelaySec string
-	PeriodSec       string
+	Namespace         string
+	Name              string
+	MaxWaitSec        string
+	QuietPeriodSec    string
+	ResyncIntervalSec string
+	IgnoredLabels     string
+
+	ReloadMode           string
+	ReloadMinIntervalSec string
+	DaemonSetNamespace   string
+	DaemonSetName        string
+	HTTPPort             string
+
+	SnippetLabelSelector string
+
+	Namespaces             string
+	NamespaceLabelSelector string
+	PrivilegedNamespaces   string
+
+	BaseConfig     baseconfig.BaseConfig
+	Labels         labels.Labels
+	LeaderElection leaderelection.LeaderElection
+	Tenants        tenants.Tenants
 }
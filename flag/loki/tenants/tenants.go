@@ -0,0 +1,6 @@
+package tenants
+
+type Tenants struct {
+	Default string
+	Mapping string
+}
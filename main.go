@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"os"
 
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/microkit/command"
@@ -10,10 +11,14 @@ import (
 	"github.com/giantswarm/versionbundle"
 	"github.com/spf13/viper"
 
+	"github.com/giantswarm/loki-operator/command/render"
 	"github.com/giantswarm/loki-operator/flag"
 	"github.com/giantswarm/loki-operator/pkg/project"
 	"github.com/giantswarm/loki-operator/server"
 	"github.com/giantswarm/loki-operator/service"
+	"github.com/giantswarm/loki-operator/service/controller/baseconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+	"github.com/giantswarm/loki-operator/service/controller/reloader"
 )
 
 var (
@@ -111,8 +116,48 @@ func mainE(ctx context.Context) error {
 	daemonCommand.PersistentFlags().String(f.Service.Kubernetes.TLS.KeyFile, "", "Key file path to use to authenticate with Kubernetes.")
 	daemonCommand.PersistentFlags().String(f.Loki.Namespace, "loki", "namespace where promtail's ConfigMap is")
 	daemonCommand.PersistentFlags().String(f.Loki.Name, "loki-promtail", "name of the promtail's ConfigMap")
-	daemonCommand.PersistentFlags().Int(f.Loki.InitialDelaySec, 30, "Initial delay for catching existing pods' config [sec]")
-	daemonCommand.PersistentFlags().Int(f.Loki.PeriodSec, 30, "Period of promtail's configmap synchronization [sec]")
+	daemonCommand.PersistentFlags().Int(f.Loki.QuietPeriodSec, 5, "Time without snippet changes after which promtail's configmap is synchronized [sec]")
+	daemonCommand.PersistentFlags().Int(f.Loki.MaxWaitSec, 60, "Maximum time a snippet change waits for promtail's configmap synchronization [sec]")
+	daemonCommand.PersistentFlags().Int(f.Loki.ResyncIntervalSec, 300, "Interval of the full resync of all pods' snippets with promtail's configmap [sec]")
+	daemonCommand.PersistentFlags().StringSlice(f.Loki.IgnoredLabels, promtailconfig.DefaultIgnoredLabels, "Pod labels never used to select the pods of a promtail job")
+	daemonCommand.PersistentFlags().String(f.Loki.SnippetLabelSelector, "", "Label selector of the snippet ConfigMaps, watched with a single informer, only the ConfigMaps referenced from pods are watched when empty")
+	daemonCommand.PersistentFlags().StringSlice(f.Loki.Namespaces, nil, "Namespaces whose pods and PromtailConfigs are watched, all namespaces are watched when empty")
+	daemonCommand.PersistentFlags().String(f.Loki.NamespaceLabelSelector, "", "Label selector restricting the namespaces whose pods and PromtailConfigs are watched")
+	daemonCommand.PersistentFlags().StringSlice(f.Loki.PrivilegedNamespaces, nil, "Namespaces whose snippets may tail any files, e.g. with static_configs or journal jobs, and discover pods of other namespaces")
+	daemonCommand.PersistentFlags().StringSlice(f.Loki.Labels.Allowed, nil, "Only label names the labels stages of snippets may promote, all label names are allowed when empty")
+	daemonCommand.PersistentFlags().StringSlice(f.Loki.Labels.Denied, promtailconfig.DefaultDeniedLabels, "Label names the labels stages of snippets must not promote")
+	daemonCommand.PersistentFlags().Int(f.Loki.Labels.Max, promtailconfig.DefaultMaxLabels, "Maximum number of labels promoted by the labels stages of a job, not limited when 0")
+	daemonCommand.PersistentFlags().String(f.Loki.ReloadMode, reloader.ModeNone, "How promtail picks up its changed config, one of none, rollout (of promtail's DaemonSet) or reload (of every promtail pod)")
+	daemonCommand.PersistentFlags().Int(f.Loki.ReloadMinIntervalSec, 300, "Minimum time between two rollouts or reloads of promtail [sec]")
+	daemonCommand.PersistentFlags().String(f.Loki.DaemonSetNamespace, "loki", "namespace where promtail's DaemonSet is")
+	daemonCommand.PersistentFlags().String(f.Loki.DaemonSetName, "loki-promtail", "name of promtail's DaemonSet")
+	daemonCommand.PersistentFlags().Int(f.Loki.HTTPPort, 3101, "Port of promtail's HTTP server serving the reload endpoint")
+	daemonCommand.PersistentFlags().String(f.Loki.BaseConfig.Inline, "", "Base of promtail's config the scrape configs are merged into, as YAML or as section of the config file")
+	daemonCommand.PersistentFlags().String(f.Loki.BaseConfig.Namespace, "", "namespace where the ConfigMap holding the base of promtail's config is")
+	daemonCommand.PersistentFlags().String(f.Loki.BaseConfig.Name, "", "name of the ConfigMap holding the base of promtail's config, which is watched for changes")
+	daemonCommand.PersistentFlags().String(f.Loki.BaseConfig.Key, baseconfig.DefaultConfigMapKey, "key of the ConfigMap holding the base of promtail's config")
+	daemonCommand.PersistentFlags().String(f.Loki.Tenants.Default, "", "Loki tenant of the namespaces neither annotated nor mapped, no tenant stage is added to the jobs when empty")
+	daemonCommand.PersistentFlags().StringSlice(f.Loki.Tenants.Mapping, nil, "Loki tenants of namespaces, as namespace=tenant")
+	daemonCommand.PersistentFlags().String(f.Loki.BaseConfig.MandatoryStages, "", "Stages put at the start (prepend) and at the end (append) of every job, as YAML or as section of the config file")
+	daemonCommand.PersistentFlags().String(f.Loki.BaseConfig.MandatoryStagesKey, baseconfig.DefaultMandatoryStagesKey, "key of the ConfigMap holding the base of promtail's config which holds the mandatory stages")
+	daemonCommand.PersistentFlags().Bool(f.Loki.LeaderElection.Enabled, false, "Elect a leader among the replicas of the operator, required when running more than one replica")
+	daemonCommand.PersistentFlags().String(f.Loki.LeaderElection.Namespace, "giantswarm", "namespace where the leader election lock ConfigMap is")
+	daemonCommand.PersistentFlags().String(f.Loki.LeaderElection.Name, "loki-operator-leader", "name of the leader election lock ConfigMap")
+
+	var renderCommand *render.Command
+	{
+		c := render.Config{
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		}
+
+		renderCommand, err = render.New(c)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	newCommand.CobraCommand().AddCommand(renderCommand.CobraCommand())
 
 	newCommand.CobraCommand().Execute()
 
@@ -0,0 +1,4 @@
+// +k8s:deepcopy-gen=package,register
+
+// +groupName=loki.giantswarm.io
+package v1alpha1
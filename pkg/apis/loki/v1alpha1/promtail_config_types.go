@@ -0,0 +1,253 @@
+package v1alpha1
+
+import (
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	kindPromtailConfig = "PromtailConfig"
+)
+
+const promtailConfigCRDYAML = `
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: promtailconfigs.loki.giantswarm.io
+spec:
+  group: loki.giantswarm.io
+  scope: Namespaced
+  version: v1alpha1
+  names:
+    kind: PromtailConfig
+    plural: promtailconfigs
+    singular: promtailconfig
+  subresources:
+    status: {}
+  additionalPrinterColumns:
+  - name: Container
+    type: string
+    JSONPath: .spec.containerName
+  - name: Accepted
+    type: boolean
+    JSONPath: .status.accepted
+  - name: Pods
+    type: integer
+    JSONPath: .status.matchedPods
+  - name: Age
+    type: date
+    JSONPath: .metadata.creationTimestamp
+  validation:
+    openAPIV3Schema:
+      properties:
+        spec:
+          type: object
+          properties:
+            podSelector:
+              type: object
+              properties:
+                matchLabels:
+                  type: object
+                matchExpressions:
+                  type: array
+                  items:
+                    type: object
+                    properties:
+                      key:
+                        type: string
+                      operator:
+                        type: string
+                        enum: ["In", "NotIn", "Exists", "DoesNotExist"]
+                      values:
+                        type: array
+                        items:
+                          type: string
+                    required: ["key", "operator"]
+            containerName:
+              type: string
+            pipelineStages:
+              type: array
+              items:
+                type: object
+          required: ["podSelector", "containerName", "pipelineStages"]
+`
+
+var promtailConfigCRD *apiextensionsv1beta1.CustomResourceDefinition
+
+func init() {
+	err := yaml.Unmarshal([]byte(promtailConfigCRDYAML), &promtailConfigCRD)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewPromtailConfigCRD returns a new custom resource definition for
+// PromtailConfig. This might look something like the following.
+//
+//     apiVersion: apiextensions.k8s.io/v1beta1
+//     kind: CustomResourceDefinition
+//     metadata:
+//       name: promtailconfigs.loki.giantswarm.io
+//     spec:
+//       group: loki.giantswarm.io
+//       scope: Namespaced
+//       version: v1alpha1
+//       names:
+//         kind: PromtailConfig
+//         plural: promtailconfigs
+//         singular: promtailconfig
+//       subresources:
+//         status: {}
+//
+func NewPromtailConfigCRD() *apiextensionsv1beta1.CustomResourceDefinition {
+	return promtailConfigCRD.DeepCopy()
+}
+
+func NewPromtailConfigTypeMeta() metav1.TypeMeta {
+	return metav1.TypeMeta{
+		APIVersion: SchemeGroupVersion.String(),
+		Kind:       kindPromtailConfig,
+	}
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PromtailConfig CRs might look something like the following.
+//
+//    apiVersion: loki.giantswarm.io/v1alpha1
+//    kind: PromtailConfig
+//    metadata:
+//      name: "apiserver"
+//      namespace: "kube-system"
+//
+//    spec:
+//      podSelector:
+//        matchLabels:
+//          app: "apiserver"
+//      containerName: "apiserver"
+//      pipelineStages:
+//      - regex:
+//          expression: '^(?P<level>[IWEF])(?P<ts>\d{4} \S+)'
+//      - labels:
+//          level: ""
+//
+//    status:
+//      accepted: true
+//      matchedPods: 3
+//      renderedGeneration: 2
+//
+type PromtailConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              PromtailConfigSpec   `json:"spec"`
+	Status            PromtailConfigStatus `json:"status" yaml:"status"`
+}
+
+type PromtailConfigSpec struct {
+	// PodSelector selects the pods in the namespace of the PromtailConfig
+	// whose logs are processed by the pipeline stages.
+	PodSelector metav1.LabelSelector `json:"podSelector" yaml:"podSelector"`
+	// ContainerName is the name of the container within the selected pods
+	// whose logs are processed by the pipeline stages,
+	// e.g. apiserver.
+	ContainerName string `json:"containerName" yaml:"containerName"`
+	// PipelineStages is the list of promtail pipeline stages applied to the
+	// log lines of the selected container, in order.
+	PipelineStages []PipelineStage `json:"pipelineStages" yaml:"pipelineStages"`
+}
+
+// PipelineStage is a single promtail pipeline stage. Exactly one of its fields
+// must be set.
+type PipelineStage struct {
+	// JSON extracts values from log lines formatted as JSON.
+	JSON *JSONStage `json:"json,omitempty" yaml:"json,omitempty"`
+	// Labels promotes extracted values to labels. Keys are label names and
+	// values the names of the extracted values. An empty value means the
+	// extracted value has the same name as the label.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Match runs nested stages only for log lines matching a selector.
+	Match *MatchStage `json:"match,omitempty" yaml:"match,omitempty"`
+	// Output replaces the log line with an extracted value.
+	Output *OutputStage `json:"output,omitempty" yaml:"output,omitempty"`
+	// Regex extracts values from log lines using named capture groups.
+	Regex *RegexStage `json:"regex,omitempty" yaml:"regex,omitempty"`
+	// Template transforms extracted values using a Go template.
+	Template *TemplateStage `json:"template,omitempty" yaml:"template,omitempty"`
+	// Timestamp sets the timestamp of the log entry from an extracted value.
+	Timestamp *TimestampStage `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
+}
+
+type JSONStage struct {
+	// Expressions maps names of extracted values to JMESPath expressions.
+	// An empty expression means the value is read from the key with the same
+	// name.
+	Expressions map[string]string `json:"expressions" yaml:"expressions"`
+	// Source is the name of the extracted value to parse instead of the log
+	// line.
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+type MatchStage struct {
+	// Selector is a LogQL stream selector,
+	// e.g. {level="error"}.
+	Selector string `json:"selector" yaml:"selector"`
+	// Stages are the pipeline stages run for matching log lines.
+	Stages []PipelineStage `json:"stages" yaml:"stages"`
+}
+
+type OutputStage struct {
+	// Source is the name of the extracted value to use as the log line.
+	Source string `json:"source" yaml:"source"`
+}
+
+type RegexStage struct {
+	// Expression is an RE2 regular expression with named capture groups.
+	Expression string `json:"expression" yaml:"expression"`
+	// Source is the name of the extracted value to parse instead of the log
+	// line.
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+type TemplateStage struct {
+	// Source is the name of the extracted value to transform.
+	Source string `json:"source" yaml:"source"`
+	// Template is the Go template applied to the extracted value.
+	Template string `json:"template" yaml:"template"`
+}
+
+type TimestampStage struct {
+	// Format is the layout of the timestamp, either a Go reference time
+	// layout or one of promtail's predefined formats,
+	// e.g. RFC3339.
+	Format string `json:"format" yaml:"format"`
+	// Location is the IANA time zone the timestamp is parsed in when it
+	// doesn't carry one,
+	// e.g. Europe/Berlin.
+	Location string `json:"location,omitempty" yaml:"location,omitempty"`
+	// Source is the name of the extracted value holding the timestamp.
+	Source string `json:"source" yaml:"source"`
+}
+
+type PromtailConfigStatus struct {
+	// Accepted tells whether the PromtailConfig was accepted and handed over
+	// to be rendered into the promtail ConfigMap.
+	Accepted bool `json:"accepted" yaml:"accepted"`
+	// MatchedPods is the number of pods currently selected by the pod
+	// selector.
+	MatchedPods int `json:"matchedPods" yaml:"matchedPods"`
+	// Reason explains why the PromtailConfig was not accepted.
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	// RenderedGeneration is the generation of the PromtailConfig last
+	// rendered into the promtail ConfigMap.
+	RenderedGeneration int64 `json:"renderedGeneration" yaml:"renderedGeneration"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type PromtailConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []PromtailConfig `json:"items"`
+}
@@ -0,0 +1,90 @@
+package promtail
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+)
+
+// UnmarshalBaseConfig parses the YAML base config, which is promtail's config
+// without the scrape configs. Unknown fields are rejected.
+func UnmarshalBaseConfig(b []byte) (*Config, error) {
+	var c Config
+	err := yaml.UnmarshalStrict(b, &c)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "%v", err)
+	}
+
+	return &c, nil
+}
+
+// ValidateBaseConfig checks the base config defines at least one client
+// pushing to Loki, and that its URLs and durations can be parsed. The scrape
+// configs are generated, so the base config must not define any.
+func ValidateBaseConfig(c *Config) error {
+	v := &validator{}
+
+	if len(c.ScrapeConfigs) > 0 {
+		v.addf("base config", "scrape_configs must not be set, they are generated")
+	}
+
+	var clients []ClientConfig
+	if c.Client != nil {
+		clients = append(clients, *c.Client)
+	}
+	clients = append(clients, c.Clients...)
+	if len(clients) == 0 {
+		v.addf("base config", "client or clients must be set")
+	}
+	for i, client := range clients {
+		path := fmt.Sprintf("client %d", i+1)
+		if client.URL == "" {
+			v.addf(path, "url must not be empty")
+		} else {
+			v.url(path, "url", client.URL)
+		}
+		v.url(path, "proxy_url", client.ProxyURL)
+		v.duration(path, "batchwait", client.BatchWait)
+		v.duration(path, "timeout", client.Timeout)
+		if client.BackoffConfig != nil {
+			v.duration(path, "backoff_config.minbackoff", client.BackoffConfig.MinBackoff)
+			v.duration(path, "backoff_config.maxbackoff", client.BackoffConfig.MaxBackoff)
+		}
+		var names []string
+		for name := range client.ExternalLabels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !labelNameRegexp.MatchString(name) {
+				v.addf(path, "external label %#q is not a valid label name", name)
+			}
+		}
+	}
+
+	if c.Positions != nil {
+		v.duration("positions", "sync_period", c.Positions.SyncPeriod)
+	}
+	if c.TargetConfig != nil {
+		v.duration("target_config", "sync_period", c.TargetConfig.SyncPeriod)
+	}
+
+	return v.err()
+}
+
+func (v *validator) url(path string, field string, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.addf(path, "%s is not a valid URL: %v", field, err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		v.addf(path, "%s must be an http or https URL", field)
+	}
+}
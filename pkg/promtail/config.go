@@ -0,0 +1,228 @@
+// Package promtail models the parts of promtail's config file the operator
+// generates. Configs are marshalled from these types, so the operator never
+// writes a config promtail can't parse.
+package promtail
+
+import (
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is promtail's config file.
+type Config struct {
+	Server        *ServerConfig    `json:"server,omitempty"`
+	Client        *ClientConfig    `json:"client,omitempty"`
+	Clients       []ClientConfig   `json:"clients,omitempty"`
+	Positions     *PositionsConfig `json:"positions,omitempty"`
+	ScrapeConfigs []ScrapeConfig   `json:"scrape_configs,omitempty"`
+	TargetConfig  *TargetConfig    `json:"target_config,omitempty"`
+}
+
+type ServerConfig struct {
+	HTTPListenAddress string `json:"http_listen_address,omitempty"`
+	HTTPListenPort    int    `json:"http_listen_port,omitempty"`
+	GRPCListenAddress string `json:"grpc_listen_address,omitempty"`
+	GRPCListenPort    int    `json:"grpc_listen_port,omitempty"`
+	LogLevel          string `json:"log_level,omitempty"`
+}
+
+type ClientConfig struct {
+	URL             string            `json:"url,omitempty"`
+	BackoffConfig   *BackoffConfig    `json:"backoff_config,omitempty"`
+	BasicAuth       *BasicAuth        `json:"basic_auth,omitempty"`
+	BatchSize       int               `json:"batchsize,omitempty"`
+	BatchWait       string            `json:"batchwait,omitempty"`
+	BearerToken     string            `json:"bearer_token,omitempty"`
+	BearerTokenFile string            `json:"bearer_token_file,omitempty"`
+	ExternalLabels  map[string]string `json:"external_labels,omitempty"`
+	ProxyURL        string            `json:"proxy_url,omitempty"`
+	TenantID        string            `json:"tenant_id,omitempty"`
+	Timeout         string            `json:"timeout,omitempty"`
+	TLSConfig       *TLSConfig        `json:"tls_config,omitempty"`
+}
+
+type BasicAuth struct {
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+type BackoffConfig struct {
+	MaxBackoff string `json:"maxbackoff,omitempty"`
+	MaxRetries int    `json:"maxretries,omitempty"`
+	MinBackoff string `json:"minbackoff,omitempty"`
+}
+
+type PositionsConfig struct {
+	Filename   string `json:"filename,omitempty"`
+	SyncPeriod string `json:"sync_period,omitempty"`
+}
+
+type TargetConfig struct {
+	SyncPeriod string `json:"sync_period,omitempty"`
+}
+
+// ScrapeConfig is a single job of promtail.
+type ScrapeConfig struct {
+	JobName             string               `json:"job_name"`
+	Journal             *JournalConfig       `json:"journal,omitempty"`
+	KubernetesSDConfigs []KubernetesSDConfig `json:"kubernetes_sd_configs,omitempty"`
+	PipelineStages      []PipelineStage      `json:"pipeline_stages,omitempty"`
+	RelabelConfigs      []RelabelConfig      `json:"relabel_configs,omitempty"`
+	StaticConfigs       []StaticConfig       `json:"static_configs,omitempty"`
+}
+
+type JournalConfig struct {
+	JSON   bool              `json:"json,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	MaxAge string            `json:"max_age,omitempty"`
+	Path   string            `json:"path,omitempty"`
+}
+
+type KubernetesSDConfig struct {
+	Role       string                `json:"role"`
+	Namespaces *KubernetesNamespaces `json:"namespaces,omitempty"`
+}
+
+type KubernetesNamespaces struct {
+	Names []string `json:"names"`
+}
+
+type RelabelConfig struct {
+	Action       string   `json:"action,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Replacement  string   `json:"replacement,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	SourceLabels []string `json:"source_labels,omitempty"`
+	TargetLabel  string   `json:"target_label,omitempty"`
+}
+
+type StaticConfig struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	Targets []string          `json:"targets,omitempty"`
+}
+
+// PipelineStage is a single stage of a pipeline. It maps the type of the
+// stage, e.g. "regex", to the configuration of the stage.
+type PipelineStage map[string]interface{}
+
+// Marshal returns the config as YAML document.
+func (c *Config) Marshal() ([]byte, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return b, nil
+}
+
+// scrapeConfigTail holds the fields of ScrapeConfig rendered after the
+// pipeline stages.
+type scrapeConfigTail struct {
+	RelabelConfigs []RelabelConfig `json:"relabel_configs,omitempty"`
+	StaticConfigs  []StaticConfig  `json:"static_configs,omitempty"`
+}
+
+// Render returns the scrape config as a YAML list item, ready to be put below
+// the scrape_configs section of the promtail config.
+func (s *ScrapeConfig) Render() (string, error) {
+	config, err := s.RenderMarked(nil)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return config, nil
+}
+
+// RenderMarked returns the scrape config like Render, with the comments of
+// stageComments appended to the first line of the pipeline stages at their
+// index.
+func (s *ScrapeConfig) RenderMarked(stageComments map[int]string) (string, error) {
+	// The fields are rendered in the order of their names, like
+	// yaml.Marshal renders the whole scrape config, and the pipeline stages
+	// one by one, so each one is known where it starts.
+	var body strings.Builder
+
+	head := ScrapeConfig{
+		JobName:             s.JobName,
+		Journal:             s.Journal,
+		KubernetesSDConfigs: s.KubernetesSDConfigs,
+	}
+	b, err := yaml.Marshal(head)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	body.Write(b)
+
+	if len(s.PipelineStages) > 0 {
+		body.WriteString("pipeline_stages:\n")
+		for i, stage := range s.PipelineStages {
+			b, err := yaml.Marshal([]PipelineStage{stage})
+			if err != nil {
+				return "", microerror.Mask(err)
+			}
+			lines := strings.SplitN(string(b), "\n", 2)
+			body.WriteString(lines[0])
+			if comment, found := stageComments[i]; found {
+				body.WriteString(" ")
+				body.WriteString(comment)
+			}
+			body.WriteString("\n")
+			body.WriteString(lines[1])
+		}
+	}
+
+	tail := scrapeConfigTail{
+		RelabelConfigs: s.RelabelConfigs,
+		StaticConfigs:  s.StaticConfigs,
+	}
+	if len(tail.RelabelConfigs) > 0 || len(tail.StaticConfigs) > 0 {
+		b, err := yaml.Marshal(tail)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		body.Write(b)
+	}
+
+	var config strings.Builder
+	for i, line := range strings.Split(strings.TrimSuffix(body.String(), "\n"), "\n") {
+		if i == 0 {
+			config.WriteString("- ")
+		} else {
+			config.WriteString("  ")
+		}
+		config.WriteString(line)
+		config.WriteString("\n")
+	}
+
+	return config.String(), nil
+}
+
+// UnmarshalScrapeConfigs parses a YAML list of scrape configs. Unknown fields
+// are rejected.
+func UnmarshalScrapeConfigs(b []byte) ([]ScrapeConfig, error) {
+	var scrapeConfigs []ScrapeConfig
+	err := yaml.UnmarshalStrict(b, &scrapeConfigs)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "%v", err)
+	}
+
+	for i, s := range scrapeConfigs {
+		if s.JobName == "" {
+			return nil, microerror.Maskf(invalidConfigError, "job_name of scrape config %d must not be empty", i+1)
+		}
+	}
+
+	return scrapeConfigs, nil
+}
@@ -0,0 +1,150 @@
+package promtail
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func Test_ScrapeConfig_RenderMarked(t *testing.T) {
+	testCases := []struct {
+		name          string
+		scrapeConfig  string
+		stageComments map[int]string
+		// expected is the rendered scrape config. When empty, the scrape
+		// config is expected to render like yaml.Marshal renders it.
+		expected string
+	}{
+		{
+			name: "case 0: job without pipeline stages",
+			scrapeConfig: `
+job_name: kube-system/nodes
+static_configs:
+- targets: [localhost]
+  labels:
+    __path__: /var/log/*.log
+`,
+		},
+		{
+			name: "case 1: job with all fields",
+			scrapeConfig: `
+job_name: default/app
+kubernetes_sd_configs:
+- role: pod
+  namespaces:
+    names: [default]
+pipeline_stages:
+- docker: {}
+- match:
+    selector: '{app="app"}'
+    stages:
+    - regex:
+        expression: '^(?P<level>\w+)'
+    - labels:
+        level:
+relabel_configs:
+- source_labels: [__meta_kubernetes_pod_label_app]
+  action: keep
+  regex: app
+`,
+		},
+		{
+			name: "case 2: stages marked, nested stages not counted",
+			scrapeConfig: `
+job_name: default/app
+kubernetes_sd_configs:
+- role: pod
+pipeline_stages:
+- replace:
+    expression: 'password=(\S+)'
+    replace: '****'
+- match:
+    selector: '{app="app"}'
+    stages:
+    - drop:
+        expression: 'GET /healthz'
+- template:
+    source: msg
+    template: |
+      first
+      - second
+- tenant:
+    value: team
+relabel_configs:
+- action: keep
+  regex: app
+`,
+			stageComments: map[int]string{
+				0: "# first",
+				2: "# third",
+				3: "# last",
+			},
+			expected: `- job_name: default/app
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - replace: # first
+      expression: password=(\S+)
+      replace: '****'
+  - match:
+      selector: '{app="app"}'
+      stages:
+      - drop:
+          expression: GET /healthz
+  - template: # third
+      source: msg
+      template: |
+        first
+        - second
+  - tenant: # last
+      value: team
+  relabel_configs:
+  - action: keep
+    regex: app
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var scrapeConfig ScrapeConfig
+			err := yaml.UnmarshalStrict([]byte(tc.scrapeConfig), &scrapeConfig)
+			if err != nil {
+				t.Fatalf("expected no error unmarshalling the scrape config, got %#q", err)
+			}
+
+			rendered, err := scrapeConfig.RenderMarked(tc.stageComments)
+			if err != nil {
+				t.Fatalf("expected no error, got %#q", err)
+			}
+
+			expected := tc.expected
+			if expected == "" {
+				b, err := yaml.Marshal([]ScrapeConfig{scrapeConfig})
+				if err != nil {
+					t.Fatalf("expected no error marshalling the scrape config, got %#q", err)
+				}
+				expected = string(b)
+			}
+			if rendered != expected {
+				t.Fatalf("expected\n%s\ngot\n%s", expected, rendered)
+			}
+
+			var parsed []ScrapeConfig
+			err = yaml.UnmarshalStrict([]byte(rendered), &parsed)
+			if err != nil {
+				t.Fatalf("expected no error parsing the rendered scrape config, got %#q", err)
+			}
+			if !reflect.DeepEqual(parsed, []ScrapeConfig{scrapeConfig}) {
+				t.Fatalf("expected the rendered scrape config to parse back, got %#v", parsed)
+			}
+			for _, comment := range tc.stageComments {
+				if strings.Count(rendered, comment) != 1 {
+					t.Fatalf("expected comment %#q once, got\n%s", comment, rendered)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package promtail
+
+import (
+	"sort"
+)
+
+// LabelNames returns the sorted names of the labels promoted by the labels
+// stages of stages, including the ones nested in match stages.
+func LabelNames(stages []PipelineStage) []string {
+	names := map[string]bool{}
+	collectLabelNames(stages, names)
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func collectLabelNames(stages []PipelineStage, names map[string]bool) {
+	for _, stage := range stages {
+		if labels, ok := stage["labels"].(map[string]interface{}); ok {
+			for name := range labels {
+				names[name] = true
+			}
+		}
+
+		collectLabelNames(nestedStages(stage), names)
+	}
+}
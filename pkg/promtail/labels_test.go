@@ -0,0 +1,90 @@
+package promtail
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func Test_LabelNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		stages   string
+		expected []string
+	}{
+		{
+			name: "case 0: no labels stage",
+			stages: `
+- docker: {}
+- regex:
+    expression: '^(?P<level>\w+)'
+`,
+			expected: nil,
+		},
+		{
+			name: "case 1: labels sorted",
+			stages: `
+- labels:
+    level:
+    component:
+    method: http_method
+`,
+			expected: []string{"component", "level", "method"},
+		},
+		{
+			name: "case 2: labels of several stages merged",
+			stages: `
+- labels:
+    level:
+- labels:
+    level:
+    component:
+`,
+			expected: []string{"component", "level"},
+		},
+		{
+			name: "case 3: labels nested in match stages",
+			stages: `
+- labels:
+    level:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        method:
+    - match:
+        selector: '{app="web", tier="frontend"}'
+        stages:
+        - labels:
+            path:
+`,
+			expected: []string{"level", "method", "path"},
+		},
+		{
+			name: "case 4: match stage without stages",
+			stages: `
+- match:
+    selector: '{app="web"}'
+    action: drop
+`,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stages []PipelineStage
+			err := yaml.Unmarshal([]byte(tc.stages), &stages)
+			if err != nil {
+				t.Fatalf("expected no error unmarshalling the stages, got %#q", err)
+			}
+
+			names := LabelNames(stages)
+
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package promtail
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// validateLogQLSelector checks selector is a LogQL stream selector, like
+// `{app="api",level=~"warn|error"}`, optionally followed by line filters, like
+// `|= "timeout"`. Regular expressions of matchers and filters are compiled.
+func validateLogQLSelector(selector string) error {
+	p := &logqlParser{input: selector}
+
+	p.skipSpace()
+	if !p.consume("{") {
+		return fmt.Errorf("must start with '{'")
+	}
+
+	matchers := 0
+	for {
+		p.skipSpace()
+		if p.consume("}") {
+			break
+		}
+		if matchers > 0 && !p.consume(",") {
+			return fmt.Errorf("expected ',' or '}' at position %d", p.pos)
+		}
+		p.skipSpace()
+
+		name := p.labelName()
+		if name == "" {
+			return fmt.Errorf("expected label name at position %d", p.pos)
+		}
+		p.skipSpace()
+		op := p.operator("=~", "!~", "!=", "=")
+		if op == "" {
+			return fmt.Errorf("expected one of =, !=, =~ or !~ after label %#q", name)
+		}
+		p.skipSpace()
+		value, err := p.quoted()
+		if err != nil {
+			return fmt.Errorf("value of label %#q: %v", name, err)
+		}
+		if op == "=~" || op == "!~" {
+			_, err = regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("regular expression of label %#q: %v", name, err)
+			}
+		}
+		matchers++
+	}
+	if matchers == 0 {
+		return fmt.Errorf("must contain at least one label matcher")
+	}
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil
+		}
+		op := p.operator("|=", "!=", "|~", "!~")
+		if op == "" {
+			return fmt.Errorf("expected line filter at position %d", p.pos)
+		}
+		p.skipSpace()
+		value, err := p.quoted()
+		if err != nil {
+			return fmt.Errorf("line filter: %v", err)
+		}
+		if op == "|~" || op == "!~" {
+			_, err = regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("regular expression of line filter: %v", err)
+			}
+		}
+	}
+}
+
+type logqlParser struct {
+	input string
+	pos   int
+}
+
+func (p *logqlParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *logqlParser) skipSpace() {
+	for !p.done() && strings.ContainsRune(" \t\n\r", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *logqlParser) consume(s string) bool {
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// operator consumes the first of ops found at the current position.
+func (p *logqlParser) operator(ops ...string) string {
+	for _, op := range ops {
+		if p.consume(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *logqlParser) labelName() string {
+	start := p.pos
+	for !p.done() {
+		c := p.input[p.pos]
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && p.pos > start) {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// quoted consumes a double quoted or backtick quoted string and returns its
+// unquoted value.
+func (p *logqlParser) quoted() (string, error) {
+	if p.done() {
+		return "", fmt.Errorf("expected quoted string")
+	}
+
+	quote := p.input[p.pos]
+	if quote != '"' && quote != '`' {
+		return "", fmt.Errorf("expected quoted string at position %d", p.pos)
+	}
+
+	end := p.pos + 1
+	for end < len(p.input) && p.input[end] != quote {
+		if quote == '"' && p.input[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.input) {
+		return "", fmt.Errorf("unterminated quoted string")
+	}
+
+	raw := p.input[p.pos : end+1]
+	p.pos = end + 1
+	if quote == '`' {
+		return raw[1 : len(raw)-1], nil
+	}
+
+	value, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted string %s: %v", raw, err)
+	}
+	return value, nil
+}
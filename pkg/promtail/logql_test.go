@@ -0,0 +1,136 @@
+package promtail
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_validateLogQLSelector(t *testing.T) {
+	testCases := []struct {
+		name     string
+		selector string
+		// errorMatch is a part of the expected error message. No error is
+		// expected when it is empty.
+		errorMatch string
+	}{
+		{
+			name:     "case 0: single matcher",
+			selector: `{app="api"}`,
+		},
+		{
+			name:     "case 1: all matcher operators with spaces",
+			selector: ` { app = "api" , level =~ "warn|error", env != "dev", team !~ "a.*" } `,
+		},
+		{
+			name:     "case 2: backtick quoted regular expression",
+			selector: "{path=~`/api/\\d+`}",
+		},
+		{
+			name:     "case 3: escaped quote in value",
+			selector: `{msg="say \"hi\""}`,
+		},
+		{
+			name:     "case 4: line filters",
+			selector: `{app="api"} |= "timeout" != "debug" |~ "err(or)?" !~ "^GET"`,
+		},
+		{
+			name:       "case 5: empty selector",
+			selector:   ``,
+			errorMatch: "must start with '{'",
+		},
+		{
+			name:       "case 6: selector without matchers",
+			selector:   `{}`,
+			errorMatch: "at least one label matcher",
+		},
+		{
+			name:       "case 7: missing braces",
+			selector:   `app="api"`,
+			errorMatch: "must start with '{'",
+		},
+		{
+			name:       "case 8: unterminated double quote",
+			selector:   `{app="api}`,
+			errorMatch: "unterminated quoted string",
+		},
+		{
+			name:       "case 9: unterminated backtick",
+			selector:   "{app=`api}",
+			errorMatch: "unterminated quoted string",
+		},
+		{
+			name:       "case 10: unterminated braces",
+			selector:   `{app="api"`,
+			errorMatch: "expected ',' or '}'",
+		},
+		{
+			name:       "case 11: trailing comma",
+			selector:   `{app="api",}`,
+			errorMatch: "expected label name",
+		},
+		{
+			name:       "case 12: missing comma",
+			selector:   `{app="api" level="warn"}`,
+			errorMatch: "expected ',' or '}'",
+		},
+		{
+			name:       "case 13: invalid regular expression of matcher",
+			selector:   `{app=~"(api"}`,
+			errorMatch: "regular expression of label `app`",
+		},
+		{
+			name:       "case 14: invalid regular expression of line filter",
+			selector:   `{app="api"} |~ "[a-"`,
+			errorMatch: "regular expression of line filter",
+		},
+		{
+			name:       "case 15: missing operator",
+			selector:   `{app}`,
+			errorMatch: "expected one of =, !=, =~ or !~ after label `app`",
+		},
+		{
+			name:       "case 16: label name starting with a digit",
+			selector:   `{1app="api"}`,
+			errorMatch: "expected label name",
+		},
+		{
+			name:       "case 17: unquoted value",
+			selector:   `{app=api}`,
+			errorMatch: "expected quoted string",
+		},
+		{
+			name:       "case 18: garbage after selector",
+			selector:   `{app="api"} timeout`,
+			errorMatch: "expected line filter",
+		},
+		{
+			name:       "case 19: line filter without value",
+			selector:   `{app="api"} |=`,
+			errorMatch: "line filter: expected quoted string",
+		},
+		{
+			name:       "case 20: invalid escape sequence",
+			selector:   `{app="a\qb"}`,
+			errorMatch: "invalid quoted string",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLogQLSelector(tc.selector)
+
+			if tc.errorMatch == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error matching %q, got none", tc.errorMatch)
+			}
+			if !strings.Contains(err.Error(), tc.errorMatch) {
+				t.Fatalf("expected error matching %q, got %v", tc.errorMatch, err)
+			}
+		})
+	}
+}
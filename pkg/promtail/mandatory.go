@@ -0,0 +1,51 @@
+package promtail
+
+import (
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+)
+
+// MandatoryStages are the pipeline stages put at the start and at the end of
+// every job, whatever the stages of the job are, e.g. to mask secrets before
+// logs leave the node.
+type MandatoryStages struct {
+	Prepend []PipelineStage `json:"prepend,omitempty"`
+	Append  []PipelineStage `json:"append,omitempty"`
+}
+
+// UnmarshalMandatoryStages parses the YAML mandatory stages. Unknown fields
+// are rejected.
+func UnmarshalMandatoryStages(b []byte) (*MandatoryStages, error) {
+	var m MandatoryStages
+	err := yaml.UnmarshalStrict(b, &m)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "%v", err)
+	}
+
+	return &m, nil
+}
+
+// ValidateMandatoryStages checks the mandatory stages are valid replace or
+// drop stages. Other stage types could extract or relabel data the stages of
+// the jobs don't expect.
+func ValidateMandatoryStages(m *MandatoryStages) error {
+	v := &validator{}
+
+	v.validateMandatoryStages("mandatory prepend stage", m.Prepend)
+	v.validateMandatoryStages("mandatory append stage", m.Append)
+
+	return v.err()
+}
+
+func (v *validator) validateMandatoryStages(path string, stages []PipelineStage) {
+	for i, stage := range stages {
+		for stageType := range stage {
+			if stageType != "replace" && stageType != "drop" {
+				v.addf(fmt.Sprintf("%s %d", path, i+1), "stage type %#q is not allowed, only replace and drop are", stageType)
+			}
+		}
+	}
+	v.validateStages(path, stages)
+}
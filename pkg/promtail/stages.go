@@ -0,0 +1,37 @@
+package promtail
+
+// HasStage tells whether stages hold a stage of stageType, including the
+// stages nested in match stages.
+func HasStage(stages []PipelineStage, stageType string) bool {
+	for _, stage := range stages {
+		if _, found := stage[stageType]; found {
+			return true
+		}
+		if HasStage(nestedStages(stage), stageType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nestedStages returns the stages nested in stage, if it is a match stage.
+func nestedStages(stage PipelineStage) []PipelineStage {
+	match, ok := stage["match"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	nested, ok := match["stages"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var stages []PipelineStage
+	for _, raw := range nested {
+		if s, ok := raw.(map[string]interface{}); ok {
+			stages = append(stages, PipelineStage(s))
+		}
+	}
+
+	return stages
+}
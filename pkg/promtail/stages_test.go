@@ -0,0 +1,76 @@
+package promtail
+
+import (
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func Test_HasStage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		stages   string
+		expected bool
+	}{
+		{
+			name:     "case 0: no stages",
+			stages:   `[]`,
+			expected: false,
+		},
+		{
+			name: "case 1: stage at the top",
+			stages: `
+- docker: {}
+- tenant:
+    value: team-a
+`,
+			expected: true,
+		},
+		{
+			name: "case 2: stage nested in match stages",
+			stages: `
+- docker: {}
+- match:
+    selector: '{app="web"}'
+    stages:
+    - match:
+        selector: '{app="web", tier="frontend"}'
+        stages:
+        - tenant:
+            source: team
+`,
+			expected: true,
+		},
+		{
+			name: "case 3: other stages only",
+			stages: `
+- docker: {}
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        level:
+- match:
+    selector: '{app="api"}'
+    action: drop
+`,
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stages []PipelineStage
+			err := yaml.Unmarshal([]byte(tc.stages), &stages)
+			if err != nil {
+				t.Fatalf("expected no error unmarshalling the stages, got %#q", err)
+			}
+
+			found := HasStage(stages, "tenant")
+
+			if found != tc.expected {
+				t.Fatalf("expected %t, got %t", tc.expected, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,463 @@
+package promtail
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+var (
+	labelNameRegexp  = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	metricNameRegexp = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+
+	// namedTimestampFormats are the formats of the timestamp stage, which
+	// are not Go reference layouts.
+	namedTimestampFormats = map[string]bool{
+		"ANSIC":       true,
+		"UnixDate":    true,
+		"RubyDate":    true,
+		"RFC822":      true,
+		"RFC822Z":     true,
+		"RFC850":      true,
+		"RFC1123":     true,
+		"RFC1123Z":    true,
+		"RFC3339":     true,
+		"RFC3339Nano": true,
+		"Unix":        true,
+		"UnixMs":      true,
+		"UnixUs":      true,
+		"UnixNs":      true,
+	}
+
+	// templateFuncs are the functions promtail provides to templates. Only
+	// their names matter for parsing.
+	templateFuncs = template.FuncMap{
+		"ToLower":                strings.ToLower,
+		"ToUpper":                strings.ToUpper,
+		"Replace":                strings.Replace,
+		"Trim":                   strings.Trim,
+		"TrimLeft":               strings.TrimLeft,
+		"TrimRight":              strings.TrimRight,
+		"TrimPrefix":             strings.TrimPrefix,
+		"TrimSuffix":             strings.TrimSuffix,
+		"TrimSpace":              strings.TrimSpace,
+		"regexReplaceAll":        func(string, string, string) string { return "" },
+		"regexReplaceAllLiteral": func(string, string, string) string { return "" },
+	}
+)
+
+type stageValidator func(v *validator, path string, config interface{})
+
+// stageValidatorFor returns the validator of the configuration of stageType.
+// It returns false for unknown stage types.
+func stageValidatorFor(stageType string) (stageValidator, bool) {
+	switch stageType {
+	case "cri", "docker":
+		return validateEmptyStage, true
+	case "drop":
+		return validateDropStage, true
+	case "json":
+		return validateJSONStage, true
+	case "labels":
+		return validateLabelsStage, true
+	case "match":
+		return validateMatchStage, true
+	case "metrics":
+		return validateMetricsStage, true
+	case "multiline":
+		return validateMultilineStage, true
+	case "output":
+		return validateOutputStage, true
+	case "regex":
+		return validateRegexStage, true
+	case "replace":
+		return validateReplaceStage, true
+	case "template":
+		return validateTemplateStage, true
+	case "tenant":
+		return validateTenantStage, true
+	case "timestamp":
+		return validateTimestampStage, true
+	}
+
+	return nil, false
+}
+
+// ValidatePipelineStages checks the stages are of known types and their
+// configuration is complete and valid. Regular expressions, templates, match
+// selectors and timestamp formats are compiled. The returned error lists all
+// the problems found.
+func ValidatePipelineStages(stages []PipelineStage) error {
+	v := &validator{}
+	v.validateStages("pipeline stage", stages)
+
+	return v.err()
+}
+
+// ValidateScrapeConfigs checks the pipeline stages of all the scrape configs.
+func ValidateScrapeConfigs(scrapeConfigs []ScrapeConfig) error {
+	v := &validator{}
+	for _, s := range scrapeConfigs {
+		v.validateStages(fmt.Sprintf("job %#q pipeline stage", s.JobName), s.PipelineStages)
+	}
+
+	return v.err()
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return microerror.Maskf(invalidConfigError, "%s", strings.Join(v.problems, "; "))
+}
+
+func (v *validator) validateStages(path string, stages []PipelineStage) {
+	for i, stage := range stages {
+		stagePath := fmt.Sprintf("%s %d", path, i+1)
+		if len(stage) != 1 {
+			v.addf(stagePath, "must define exactly one stage type, found %d", len(stage))
+			continue
+		}
+
+		for stageType, config := range stage {
+			validate, found := stageValidatorFor(stageType)
+			if !found {
+				v.addf(stagePath, "unknown stage type %#q", stageType)
+				continue
+			}
+			validate(v, fmt.Sprintf("%s (%s)", stagePath, stageType), config)
+		}
+	}
+}
+
+// fields returns the configuration of a stage as map. It reports fields not
+// in allowed.
+func (v *validator) fields(path string, config interface{}, allowed ...string) map[string]interface{} {
+	if config == nil {
+		return map[string]interface{}{}
+	}
+	m, ok := config.(map[string]interface{})
+	if !ok {
+		v.addf(path, "must be a mapping")
+		return map[string]interface{}{}
+	}
+
+	isAllowed := map[string]bool{}
+	for _, a := range allowed {
+		isAllowed[a] = true
+	}
+	for _, field := range sortedKeys(m) {
+		if !isAllowed[field] {
+			v.addf(path, "unknown field %#q", field)
+		}
+	}
+
+	return m
+}
+
+// str returns the string field of a stage. It reports missing required fields
+// and fields which are not strings.
+func (v *validator) str(path string, m map[string]interface{}, field string, required bool) string {
+	value, found := m[field]
+	if !found || value == nil {
+		if required {
+			v.addf(path, "%s must not be empty", field)
+		}
+		return ""
+	}
+	s, ok := value.(string)
+	if !ok {
+		v.addf(path, "%s must be a string", field)
+		return ""
+	}
+	if required && s == "" {
+		v.addf(path, "%s must not be empty", field)
+	}
+
+	return s
+}
+
+func (v *validator) regex(path string, field string, expression string) {
+	if expression == "" {
+		return
+	}
+	_, err := regexp.Compile(expression)
+	if err != nil {
+		v.addf(path, "%s is not a valid regular expression: %v", field, err)
+	}
+}
+
+func (v *validator) duration(path string, field string, value string) {
+	if value == "" {
+		return
+	}
+	_, err := time.ParseDuration(value)
+	if err != nil {
+		v.addf(path, "%s is not a valid duration: %v", field, err)
+	}
+}
+
+func validateEmptyStage(v *validator, path string, config interface{}) {
+	v.fields(path, config)
+}
+
+func validateDropStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "source", "expression", "value", "older_than", "longer_than", "drop_counter_reason")
+	v.str(path, m, "source", false)
+	v.regex(path, "expression", v.str(path, m, "expression", false))
+	v.duration(path, "older_than", v.str(path, m, "older_than", false))
+
+	if m["expression"] != nil && m["value"] != nil {
+		v.addf(path, "expression and value must not both be set")
+	}
+	if m["source"] == nil && m["expression"] == nil && m["value"] == nil && m["older_than"] == nil && m["longer_than"] == nil {
+		v.addf(path, "must define at least one of source, expression, value, older_than or longer_than")
+	}
+}
+
+func validateJSONStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "expressions", "source")
+	v.str(path, m, "source", false)
+
+	expressions, ok := m["expressions"].(map[string]interface{})
+	if !ok || len(expressions) == 0 {
+		v.addf(path, "expressions must be a non-empty mapping")
+		return
+	}
+	for _, name := range sortedKeys(expressions) {
+		expression := expressions[name]
+		if expression == nil {
+			continue
+		}
+		if _, ok := expression.(string); !ok {
+			v.addf(path, "expression of %#q must be a string", name)
+		}
+	}
+}
+
+func validateLabelsStage(v *validator, path string, config interface{}) {
+	labels, ok := config.(map[string]interface{})
+	if !ok || len(labels) == 0 {
+		v.addf(path, "must be a non-empty mapping of label names to sources")
+		return
+	}
+	for _, name := range sortedKeys(labels) {
+		source := labels[name]
+		if !labelNameRegexp.MatchString(name) {
+			v.addf(path, "%#q is not a valid label name", name)
+		}
+		if source == nil {
+			continue
+		}
+		if _, ok := source.(string); !ok {
+			v.addf(path, "source of label %#q must be a string", name)
+		}
+	}
+}
+
+func validateMatchStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "selector", "stages", "action", "pipeline_name", "drop_counter_reason")
+	selector := v.str(path, m, "selector", true)
+	if selector != "" {
+		err := validateLogQLSelector(selector)
+		if err != nil {
+			v.addf(path, "selector %#q is not a valid LogQL stream selector: %v", selector, err)
+		}
+	}
+	v.str(path, m, "pipeline_name", false)
+
+	action := v.str(path, m, "action", false)
+	switch action {
+	case "", "keep":
+	case "drop":
+		if m["stages"] != nil {
+			v.addf(path, "stages must not be set for action %#q", action)
+		}
+		return
+	default:
+		v.addf(path, "action must be keep or drop, got %#q", action)
+		return
+	}
+
+	rawStages, ok := m["stages"].([]interface{})
+	if !ok || len(rawStages) == 0 {
+		v.addf(path, "stages must be a non-empty list")
+		return
+	}
+	var stages []PipelineStage
+	for i, raw := range rawStages {
+		stage, ok := raw.(map[string]interface{})
+		if !ok {
+			v.addf(path, "stage %d must be a mapping", i+1)
+			continue
+		}
+		stages = append(stages, PipelineStage(stage))
+	}
+	v.validateStages(path+" stage", stages)
+}
+
+func validateMetricsStage(v *validator, path string, config interface{}) {
+	metrics, ok := config.(map[string]interface{})
+	if !ok || len(metrics) == 0 {
+		v.addf(path, "must be a non-empty mapping of metric names to metrics")
+		return
+	}
+
+	for _, name := range sortedKeys(metrics) {
+		metric := metrics[name]
+		metricPath := fmt.Sprintf("%s metric %#q", path, name)
+		if !metricNameRegexp.MatchString(name) {
+			v.addf(metricPath, "not a valid metric name")
+		}
+
+		m := v.fields(metricPath, metric, "type", "description", "source", "prefix", "max_idle_duration", "config")
+		v.str(metricPath, m, "description", false)
+		v.str(metricPath, m, "source", false)
+		v.str(metricPath, m, "prefix", false)
+		v.duration(metricPath, "max_idle_duration", v.str(metricPath, m, "max_idle_duration", false))
+
+		metricType := strings.ToLower(v.str(metricPath, m, "type", true))
+		switch metricType {
+		case "":
+		case "counter":
+			c := v.fields(metricPath+" config", m["config"], "value", "action", "match_all", "count_entry_bytes")
+			action := v.str(metricPath+" config", c, "action", true)
+			if action != "" && action != "inc" && action != "add" {
+				v.addf(metricPath, "counter action must be inc or add, got %#q", action)
+			}
+		case "gauge":
+			c := v.fields(metricPath+" config", m["config"], "value", "action")
+			action := v.str(metricPath+" config", c, "action", true)
+			switch action {
+			case "", "set", "inc", "dec", "add", "sub":
+			default:
+				v.addf(metricPath, "gauge action must be one of set, inc, dec, add or sub, got %#q", action)
+			}
+		case "histogram":
+			c := v.fields(metricPath+" config", m["config"], "value", "buckets")
+			buckets, ok := c["buckets"].([]interface{})
+			if !ok || len(buckets) == 0 {
+				v.addf(metricPath, "histogram buckets must be a non-empty list")
+			}
+			for _, b := range buckets {
+				if _, ok := b.(float64); !ok {
+					v.addf(metricPath, "histogram buckets must be numbers")
+					break
+				}
+			}
+		default:
+			v.addf(metricPath, "type must be Counter, Gauge or Histogram, got %#q", metricType)
+		}
+	}
+}
+
+func validateMultilineStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "firstline", "max_wait_time", "max_lines")
+	v.regex(path, "firstline", v.str(path, m, "firstline", true))
+	v.duration(path, "max_wait_time", v.str(path, m, "max_wait_time", false))
+
+	if maxLines, found := m["max_lines"]; found {
+		if n, ok := maxLines.(float64); !ok || n <= 0 || n != float64(int64(n)) {
+			v.addf(path, "max_lines must be a positive integer")
+		}
+	}
+}
+
+func validateOutputStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "source")
+	v.str(path, m, "source", true)
+}
+
+func validateRegexStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "expression", "source")
+	v.regex(path, "expression", v.str(path, m, "expression", true))
+	v.str(path, m, "source", false)
+}
+
+func validateReplaceStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "expression", "source", "replace")
+	v.regex(path, "expression", v.str(path, m, "expression", true))
+	v.str(path, m, "source", false)
+	v.str(path, m, "replace", false)
+}
+
+func validateTemplateStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "source", "template")
+	v.str(path, m, "source", true)
+
+	text := v.str(path, m, "template", true)
+	if text == "" {
+		return
+	}
+	_, err := template.New("").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		v.addf(path, "template is not valid: %v", err)
+	}
+}
+
+func validateTenantStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "source", "value")
+	source := v.str(path, m, "source", false)
+	value := v.str(path, m, "value", false)
+	if (source == "") == (value == "") {
+		v.addf(path, "exactly one of source and value must be set")
+	}
+}
+
+func validateTimestampStage(v *validator, path string, config interface{}) {
+	m := v.fields(path, config, "source", "format", "fallback_formats", "location", "action_on_failure")
+	v.str(path, m, "source", true)
+	v.str(path, m, "location", false)
+
+	format := v.str(path, m, "format", true)
+	if format != "" && !isValidTimestampFormat(format) {
+		v.addf(path, "format %#q is neither a known format nor a Go reference layout", format)
+	}
+
+	action := v.str(path, m, "action_on_failure", false)
+	if action != "" && action != "fudge" && action != "skip" {
+		v.addf(path, "action_on_failure must be fudge or skip, got %#q", action)
+	}
+}
+
+// isValidTimestampFormat tells whether format is a named format or a Go
+// reference layout, which formats and parses a time consistently.
+func isValidTimestampFormat(format string) bool {
+	if namedTimestampFormats[format] {
+		return true
+	}
+
+	// The sample differs from the reference time in every element, so a
+	// layout without any reference element formats to itself.
+	sample := time.Date(2019, time.November, 23, 17, 38, 49, 123456789, time.UTC)
+	formatted := sample.Format(format)
+	if formatted == format {
+		return false
+	}
+	_, err := time.Parse(format, formatted)
+	return err == nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
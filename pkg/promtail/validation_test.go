@@ -0,0 +1,278 @@
+package promtail
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func Test_ValidatePipelineStages(t *testing.T) {
+	testCases := []struct {
+		name   string
+		stages string
+		// errorMatches are parts of the expected error message. No error is
+		// expected when it is empty.
+		errorMatches []string
+	}{
+		{
+			name: "case 0: valid stages of all types",
+			stages: `
+- docker: {}
+- cri: {}
+- regex:
+    expression: '^(?P<level>\w+) (?P<msg>.*)$'
+- json:
+    expressions:
+      user: user.name
+- labels:
+    level:
+- template:
+    source: level
+    template: '{{ ToLower .Value }}'
+- timestamp:
+    source: time
+    format: RFC3339
+- timestamp:
+    source: time
+    format: '2006-01-02 15:04:05'
+    action_on_failure: skip
+- multiline:
+    firstline: '^\d{4}'
+    max_wait_time: 3s
+    max_lines: 128
+- metrics:
+    lines_total:
+      type: Counter
+      config:
+        action: inc
+    size:
+      type: Histogram
+      config:
+        buckets: [1, 10, 100]
+- output:
+    source: msg
+- replace:
+    expression: 'password=(\S+)'
+    replace: '****'
+- drop:
+    expression: 'GET /healthz'
+- tenant:
+    value: team-a
+- match:
+    selector: '{app="api"} |= "error"'
+    stages:
+    - labels:
+        msg:
+- match:
+    selector: '{app="api"}'
+    action: drop
+`,
+		},
+		{
+			name: "case 1: unknown stage type",
+			stages: `
+- grep:
+    expression: x
+`,
+			errorMatches: []string{"pipeline stage 1: unknown stage type `grep`"},
+		},
+		{
+			name: "case 2: more than one stage type",
+			stages: `
+- regex:
+    expression: x
+  output:
+    source: x
+`,
+			errorMatches: []string{"must define exactly one stage type, found 2"},
+		},
+		{
+			name: "case 3: unknown field",
+			stages: `
+- regex:
+    expression: x
+    sources: y
+`,
+			errorMatches: []string{"unknown field `sources`"},
+		},
+		{
+			name: "case 4: invalid and missing regular expressions",
+			stages: `
+- regex:
+    expression: '(?P<level'
+- replace: {}
+`,
+			errorMatches: []string{
+				"pipeline stage 1 (regex): expression is not a valid regular expression",
+				"pipeline stage 2 (replace): expression must not be empty",
+			},
+		},
+		{
+			name: "case 5: invalid label names and sources",
+			stages: `
+- labels:
+    1level:
+    user: [name]
+`,
+			errorMatches: []string{
+				"`1level` is not a valid label name",
+				"source of label `user` must be a string",
+			},
+		},
+		{
+			name: "case 6: empty labels stage",
+			stages: `
+- labels: {}
+`,
+			errorMatches: []string{"must be a non-empty mapping of label names to sources"},
+		},
+		{
+			name: "case 7: invalid match selector",
+			stages: `
+- match:
+    selector: '{app="api",}'
+    stages:
+    - output:
+        source: msg
+`,
+			errorMatches: []string{"selector `{app=\"api\",}` is not a valid LogQL stream selector"},
+		},
+		{
+			name: "case 8: invalid nested stage of match stage",
+			stages: `
+- match:
+    selector: '{app="api"}'
+    stages:
+    - labels:
+        1level:
+`,
+			errorMatches: []string{"pipeline stage 1 (match) stage 1 (labels): `1level` is not a valid label name"},
+		},
+		{
+			name: "case 9: match stage without stages",
+			stages: `
+- match:
+    selector: '{app="api"}'
+`,
+			errorMatches: []string{"stages must be a non-empty list"},
+		},
+		{
+			name: "case 10: match stage dropping with stages",
+			stages: `
+- match:
+    selector: '{app="api"}'
+    action: drop
+    stages:
+    - output:
+        source: msg
+`,
+			errorMatches: []string{"stages must not be set for action `drop`"},
+		},
+		{
+			name: "case 11: invalid timestamp format and action",
+			stages: `
+- timestamp:
+    source: time
+    format: yesterday
+    action_on_failure: retry
+`,
+			errorMatches: []string{
+				"format `yesterday` is neither a known format nor a Go reference layout",
+				"action_on_failure must be fudge or skip, got `retry`",
+			},
+		},
+		{
+			name: "case 12: invalid template",
+			stages: `
+- template:
+    source: level
+    template: '{{ .Value'
+`,
+			errorMatches: []string{"template is not valid"},
+		},
+		{
+			name: "case 13: tenant with both source and value",
+			stages: `
+- tenant:
+    source: team
+    value: team-a
+`,
+			errorMatches: []string{"exactly one of source and value must be set"},
+		},
+		{
+			name: "case 14: invalid metrics",
+			stages: `
+- metrics:
+    lines-total:
+      type: Counter
+      config:
+        action: set
+    size:
+      type: Summary
+`,
+			errorMatches: []string{
+				"metric `lines-total`: not a valid metric name",
+				"counter action must be inc or add, got `set`",
+				"type must be Counter, Gauge or Histogram, got `summary`",
+			},
+		},
+		{
+			name: "case 15: invalid multiline stage",
+			stages: `
+- multiline:
+    firstline: '^\d{4}'
+    max_wait_time: soon
+    max_lines: 1.5
+`,
+			errorMatches: []string{
+				"max_wait_time is not a valid duration",
+				"max_lines must be a positive integer",
+			},
+		},
+		{
+			name: "case 16: drop stage without criteria",
+			stages: `
+- drop:
+    drop_counter_reason: noise
+`,
+			errorMatches: []string{"must define at least one of source, expression, value, older_than or longer_than"},
+		},
+		{
+			name: "case 17: drop stage with expression and value",
+			stages: `
+- drop:
+    expression: x
+    value: y
+`,
+			errorMatches: []string{"expression and value must not both be set"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stages []PipelineStage
+			err := yaml.Unmarshal([]byte(tc.stages), &stages)
+			if err != nil {
+				t.Fatalf("failed to parse stages: %v", err)
+			}
+
+			err = ValidatePipelineStages(stages)
+
+			if len(tc.errorMatches) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if !IsInvalidConfig(err) {
+				t.Fatalf("expected invalid config error, got %v", err)
+			}
+			for _, match := range tc.errorMatches {
+				if !strings.Contains(err.Error(), match) {
+					t.Errorf("expected error matching %q, got %v", match, err)
+				}
+			}
+		})
+	}
+}
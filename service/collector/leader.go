@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"github.com/giantswarm/microerror"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/giantswarm/loki-operator/service/controller/leader"
+)
+
+var (
+	leaderDesc *prometheus.Desc = prometheus.NewDesc(
+		prometheus.BuildFQName("loki_operator", "leader_election", "is_leader"),
+		"Whether this replica of the operator is the leader writing promtail's config map (1) or not (0).",
+		nil,
+		nil,
+	)
+)
+
+type LeaderConfig struct {
+	Elector *leader.Elector
+}
+
+type Leader struct {
+	elector *leader.Elector
+}
+
+func NewLeader(config LeaderConfig) (*Leader, error) {
+	if config.Elector == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Elector must not be empty", config)
+	}
+
+	l := &Leader{
+		elector: config.Elector,
+	}
+
+	return l, nil
+}
+
+func (l *Leader) Collect(ch chan<- prometheus.Metric) error {
+	value := 0.0
+	if l.elector.IsLeader() {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(leaderDesc, prometheus.GaugeValue, value)
+
+	return nil
+}
+
+func (l *Leader) Describe(ch chan<- *prometheus.Desc) error {
+	ch <- leaderDesc
+
+	return nil
+}
@@ -5,11 +5,14 @@ import (
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/micrologger"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/giantswarm/loki-operator/service/controller/leader"
 )
 
 type SetConfig struct {
-	K8sClient kubernetes.Interface
-	Logger    micrologger.Logger
+	K8sClient     kubernetes.Interface
+	LeaderElector *leader.Elector
+	Logger        micrologger.Logger
 }
 
 // Set is basically only a wrapper for the operator's collector implementations.
@@ -28,10 +31,16 @@ func NewSet(config SetConfig) (*Set, error) {
 		return nil, microerror.Mask(err)
 	}
 
+	leaderCollector, err := NewLeader(LeaderConfig{Elector: config.LeaderElector})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
 	var collectorSet *collector.Set
 	{
 		c := collector.SetConfig{
 			Collectors: []collector.Interface{
+				leaderCollector,
 				todo,
 			},
 			Logger: config.Logger,
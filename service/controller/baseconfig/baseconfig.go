@@ -0,0 +1,257 @@
+// Package baseconfig provides the base of promtail's config, i.e. everything
+// but the scrape configs, which are generated from the snippets, and the
+// mandatory stages put into every job. Both are either given inline in the
+// operator's config or read from a ConfigMap, which is watched, so promtail's
+// config is re-rendered whenever they change.
+package baseconfig
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+const (
+	// DefaultConfigMapKey is the key of the ConfigMap holding the base
+	// config, unless configured otherwise.
+	DefaultConfigMapKey = "promtail.yaml"
+	// DefaultMandatoryStagesKey is the key of the ConfigMap holding the
+	// mandatory stages, unless configured otherwise.
+	DefaultMandatoryStagesKey = "mandatory-stages.yaml"
+
+	// resyncPeriod is zero, because the base config is only reloaded when
+	// its ConfigMap changes.
+	resyncPeriod = 0
+)
+
+type Config struct {
+	K8sClient         kubernetes.Interface
+	Logger            micrologger.Logger
+	Handler           promtailconfig.Handler
+	PromtailConfigMap *promtailconfig.PromtailConfigMap
+
+	// Inline is the YAML base config given in the operator's config.
+	Inline string
+	// ConfigMapNamespace, ConfigMapName and ConfigMapKey identify the
+	// ConfigMap key holding the YAML base config. ConfigMapKey defaults to
+	// DefaultConfigMapKey.
+	ConfigMapNamespace string
+	ConfigMapName      string
+	ConfigMapKey       string
+
+	// MandatoryStagesInline are the YAML mandatory stages given in the
+	// operator's config.
+	MandatoryStagesInline string
+	// MandatoryStagesKey is the key of the ConfigMap holding the YAML
+	// mandatory stages, which are optional. It defaults to
+	// DefaultMandatoryStagesKey.
+	MandatoryStagesKey string
+}
+
+// Loader sets the base of promtail's config. With neither an inline base nor a
+// ConfigMap configured, the built-in default base is kept.
+type Loader struct {
+	handler           promtailconfig.Handler
+	logger            micrologger.Logger
+	promtailConfigMap *promtailconfig.PromtailConfigMap
+
+	configMapKey       string
+	mandatoryStagesKey string
+	informer           cache.SharedIndexInformer
+
+	// mandatoryStagesLoaded tells whether mandatory stages were loaded from
+	// the ConfigMap. It is only accessed by the informer's event handlers,
+	// which are never called concurrently.
+	mandatoryStagesLoaded bool
+}
+
+func New(config Config) (*Loader, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Handler == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Handler must not be empty", config)
+	}
+	if config.PromtailConfigMap == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PromtailConfigMap must not be empty", config)
+	}
+	if config.Inline != "" && config.ConfigMapName != "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Inline and %T.ConfigMapName must not both be set", config, config)
+	}
+	if config.MandatoryStagesInline != "" && config.ConfigMapName != "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.MandatoryStagesInline and %T.ConfigMapName must not both be set", config, config)
+	}
+	if config.ConfigMapName != "" && config.ConfigMapNamespace == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.ConfigMapNamespace must not be empty", config)
+	}
+
+	l := &Loader{
+		handler:           config.Handler,
+		logger:            config.Logger,
+		promtailConfigMap: config.PromtailConfigMap,
+
+		configMapKey:       config.ConfigMapKey,
+		mandatoryStagesKey: config.MandatoryStagesKey,
+	}
+	if l.configMapKey == "" {
+		l.configMapKey = DefaultConfigMapKey
+	}
+	if l.mandatoryStagesKey == "" {
+		l.mandatoryStagesKey = DefaultMandatoryStagesKey
+	}
+
+	if config.MandatoryStagesInline != "" {
+		mandatory, err := ParseMandatoryStages(config.MandatoryStagesInline)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "%T.MandatoryStagesInline: %v", config, err)
+		}
+		l.promtailConfigMap.SetMandatoryStages(mandatory)
+	}
+
+	if config.Inline != "" {
+		// An invalid inline base is a misconfiguration of the operator, it
+		// never gets better while running.
+		base, err := Parse(config.Inline)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "%T.Inline: %v", config, err)
+		}
+		l.promtailConfigMap.SetBase(base)
+	} else if config.ConfigMapName != "" {
+		// promtail's config isn't written before the base is loaded from the
+		// ConfigMap.
+		l.promtailConfigMap.SetBase(nil)
+
+		lw := cache.NewListWatchFromClient(config.K8sClient.CoreV1().RESTClient(), "configmaps", config.ConfigMapNamespace,
+			fields.OneTermEqualSelector("metadata.name", config.ConfigMapName))
+		l.informer = cache.NewSharedIndexInformer(lw, &v1.ConfigMap{}, resyncPeriod, cache.Indexers{})
+		l.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    l.load,
+			UpdateFunc: func(_, newObj interface{}) { l.load(newObj) },
+			DeleteFunc: l.deleted,
+		})
+	} else {
+		l.logger.Log("level", "warning", "message", "no base config configured, using the default one without client URL")
+	}
+
+	return l, nil
+}
+
+// Boot watches the ConfigMap holding the base config until ctx is canceled.
+// It returns immediately when the base isn't read from a ConfigMap.
+func (l *Loader) Boot(ctx context.Context) {
+	if l.informer == nil {
+		return
+	}
+
+	l.informer.Run(ctx.Done())
+}
+
+// load sets the base config and the mandatory stages held by the ConfigMap and
+// schedules a write of promtail's config. Invalid base configs and mandatory
+// stages are rejected and the former ones are kept.
+func (l *Loader) load(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+
+	l.loadMandatoryStages(cm)
+	l.loadBase(cm)
+	l.handler.Refresh()
+}
+
+// loadBase sets the base config held by the ConfigMap.
+func (l *Loader) loadBase(cm *v1.ConfigMap) {
+	content, found := cm.Data[l.configMapKey]
+	if !found {
+		l.logger.Log("level", "error", "message", fmt.Sprintf("key %#q missing in base config map, keeping the former base config", l.configMapKey),
+			"configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name))
+		return
+	}
+
+	base, err := Parse(content)
+	if err != nil {
+		l.logger.Log("level", "error", "message", "rejected base config, keeping the former one",
+			"configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name), "reason", err.Error())
+		return
+	}
+
+	l.logger.Log("level", "info", "message", "loaded base config", "configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name))
+	l.promtailConfigMap.SetBase(base)
+}
+
+// loadMandatoryStages sets the mandatory stages held by the ConfigMap. A
+// missing key keeps the former stages, so they never get lost by accident,
+// an empty document removes them.
+func (l *Loader) loadMandatoryStages(cm *v1.ConfigMap) {
+	content, found := cm.Data[l.mandatoryStagesKey]
+	if !found {
+		if l.mandatoryStagesLoaded {
+			l.logger.Log("level", "error", "message", fmt.Sprintf("key %#q missing in base config map, keeping the former mandatory stages, "+
+				"set it to an empty document to remove them", l.mandatoryStagesKey),
+				"configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name))
+		}
+		return
+	}
+
+	mandatory, err := ParseMandatoryStages(content)
+	if err != nil {
+		l.logger.Log("level", "error", "message", "rejected mandatory stages, keeping the former ones",
+			"configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name), "reason", err.Error())
+		return
+	}
+
+	if len(mandatory.Prepend) == 0 && len(mandatory.Append) == 0 {
+		l.logger.Log("level", "info", "message", "removed mandatory stages", "configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name))
+	} else {
+		l.logger.Log("level", "info", "message", "loaded mandatory stages", "configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name))
+	}
+	l.promtailConfigMap.SetMandatoryStages(mandatory)
+	l.mandatoryStagesLoaded = true
+}
+
+func (l *Loader) deleted(obj interface{}) {
+	l.logger.Log("level", "warning", "message", "base config map deleted, keeping the former base config")
+}
+
+// Parse returns the base config held by the YAML content, after validating
+// it.
+func Parse(content string) (*promtail.Config, error) {
+	base, err := promtail.UnmarshalBaseConfig([]byte(content))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	err = promtail.ValidateBaseConfig(base)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return base, nil
+}
+
+// ParseMandatoryStages returns the mandatory stages held by the YAML content,
+// after validating them. An empty document holds no stages.
+func ParseMandatoryStages(content string) (*promtail.MandatoryStages, error) {
+	mandatory, err := promtail.UnmarshalMandatoryStages([]byte(content))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	err = promtail.ValidateMandatoryStages(mandatory)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return mandatory, nil
+}
@@ -0,0 +1,202 @@
+// Package events emits Kubernetes Events about the promtail snippets of pods
+// on the pods and on the ConfigMaps holding the snippets, so app teams see
+// with `kubectl describe` why their logs aren't parsed.
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/giantswarm/loki-operator/service/controller/leader"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+const (
+	// ReasonInvalidPodConfig is used when the logging containers of a pod
+	// can't be resolved, e.g. because a configured container doesn't exist.
+	ReasonInvalidPodConfig = "InvalidPromtailConfig"
+	// ReasonSnippetNotFound is used when the ConfigMap or the key holding a
+	// snippet doesn't exist.
+	ReasonSnippetNotFound = "PromtailSnippetNotFound"
+	// ReasonSnippetRejected is used when a snippet fails validation.
+	ReasonSnippetRejected = "PromtailSnippetRejected"
+	// ReasonSnippetConflict is used when pods sharing a Key use different
+	// snippets.
+	ReasonSnippetConflict = "PromtailSnippetConflict"
+	// ReasonSnippetAccepted is used when a snippet rejected before passes
+	// validation.
+	ReasonSnippetAccepted = "PromtailSnippetAccepted"
+	// ReasonSnippetRendered is used when the snippet of a pod got rendered
+	// into promtail's ConfigMap.
+	ReasonSnippetRendered = "PromtailSnippetRendered"
+)
+
+type Config struct {
+	K8sClient     kubernetes.Interface
+	LeaderElector *leader.Elector
+	Logger        micrologger.Logger
+
+	// Component is the source of the Events, usually the operator's name.
+	Component string
+	// PromtailConfigMap is the namespace/name of promtail's ConfigMap,
+	// which is named in the Events about rendered snippets.
+	PromtailConfigMap string
+}
+
+// Recorder emits the Events. Besides emitting Events directly, it remembers
+// the pods whose snippets got registered and tells them once their snippets
+// are rendered into promtail's ConfigMap. Only the leader emits Events, the
+// other replicas drop them. Recorder implements
+// promtailconfig.RenderObserver.
+type Recorder struct {
+	broadcaster       record.EventBroadcaster
+	k8sClient         kubernetes.Interface
+	leaderElector     *leader.Elector
+	logger            micrologger.Logger
+	promtailConfigMap string
+	recorder          record.EventRecorder
+
+	// mutex guards pods and announced.
+	mutex sync.Mutex
+	// pods maps the UIDs of the watched pods to their references.
+	pods map[types.UID]*v1.ObjectReference
+	// announced holds the snippets last announced as rendered for every
+	// registration, so every snippet is announced only once.
+	announced map[registrationID]string
+}
+
+type registrationID struct {
+	Key    promtailconfig.Key
+	Source types.UID
+}
+
+func New(config Config) (*Recorder, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.LeaderElector == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.LeaderElector must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Component == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Component must not be empty", config)
+	}
+	if config.PromtailConfigMap == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PromtailConfigMap must not be empty", config)
+	}
+
+	broadcaster := record.NewBroadcaster()
+
+	r := &Recorder{
+		broadcaster:       broadcaster,
+		k8sClient:         config.K8sClient,
+		leaderElector:     config.LeaderElector,
+		logger:            config.Logger,
+		promtailConfigMap: config.PromtailConfigMap,
+		recorder:          broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: config.Component}),
+
+		pods:      make(map[types.UID]*v1.ObjectReference),
+		announced: make(map[registrationID]string),
+	}
+
+	return r, nil
+}
+
+// Boot sends the recorded Events to the Kubernetes API until ctx is canceled.
+func (r *Recorder) Boot(ctx context.Context) {
+	watcher := r.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: r.k8sClient.CoreV1().Events(""),
+	})
+
+	<-ctx.Done()
+	watcher.Stop()
+}
+
+// Normal emits an Event of type Normal on obj, if the replica leads.
+func (r *Recorder) Normal(obj runtime.Object, reason, messageFmt string, args ...interface{}) {
+	if !r.leaderElector.IsLeader() {
+		return
+	}
+	r.recorder.Eventf(obj, v1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// Warning emits an Event of type Warning on obj, if the replica leads.
+func (r *Recorder) Warning(obj runtime.Object, reason, messageFmt string, args ...interface{}) {
+	if !r.leaderElector.IsLeader() {
+		return
+	}
+	r.recorder.Eventf(obj, v1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+// Watch makes the recorder tell pod once its snippets are rendered.
+func (r *Recorder) Watch(pod *v1.Pod) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pods[pod.UID] = &v1.ObjectReference{
+		APIVersion:      "v1",
+		Kind:            "Pod",
+		Namespace:       pod.Namespace,
+		Name:            pod.Name,
+		UID:             pod.UID,
+		ResourceVersion: pod.ResourceVersion,
+	}
+}
+
+// Forget stops telling the pod identified by source about its snippets.
+func (r *Recorder) Forget(source types.UID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.pods, source)
+	for id := range r.announced {
+		if id.Source == source {
+			delete(r.announced, id)
+		}
+	}
+}
+
+// Rendered emits an Event on every watched pod whose snippet got rendered
+// into promtail's ConfigMap since it was announced last.
+func (r *Recorder) Rendered(registrations []promtailconfig.Registration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rendered := map[registrationID]bool{}
+	for _, reg := range registrations {
+		id := registrationID{Key: reg.Key, Source: reg.Source}
+		rendered[id] = true
+
+		pod, found := r.pods[reg.Source]
+		if !found {
+			continue
+		}
+		if r.announced[id] == reg.Snippet {
+			continue
+		}
+
+		r.Normal(pod, ReasonSnippetRendered, "snippet of container %#q rendered into %s", reg.Key.ContainerName,
+			r.promtailConfigMap)
+		r.announced[id] = reg.Snippet
+	}
+
+	// Snippets dropped from promtail's ConfigMap are announced again once
+	// they are back.
+	for id := range r.announced {
+		if !rendered[id] {
+			delete(r.announced, id)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/giantswarm/k8sclient"
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+type LokiOperatorConfig struct {
+	PromtailConfigmapNamespace string
+	PromtailConfigmapName      string
+	MaxWaitSec                 int
+	QuietPeriodSec             int
+	PrivilegedNamespaces       []string
+	AllowedLabels              []string
+	DeniedLabels               []string
+	MaxLabels                  int
+}
+
+// NewPromtailConfigMap creates the accessor of promtail's ConfigMap.
+func NewPromtailConfigMap(k8sClient k8sclient.Interface, config LokiOperatorConfig) (*promtailconfig.PromtailConfigMap, error) {
+	pc, err := promtailconfig.NewPromtailConfigMap(k8sClient, config.PromtailConfigmapNamespace,
+		config.PromtailConfigmapName, podconfig.PromtailConfigMapKeyName)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	policy, err := NewPolicy(config)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	pc.SetPolicy(policy)
+
+	return pc, nil
+}
+
+// NewPolicy creates the policy snippets are checked against, shared by the
+// operator and the render command.
+func NewPolicy(config LokiOperatorConfig) (*promtailconfig.Policy, error) {
+	c := promtailconfig.PolicyConfig{
+		PrivilegedNamespaces: config.PrivilegedNamespaces,
+
+		AllowedLabels: config.AllowedLabels,
+		DeniedLabels:  config.DeniedLabels,
+		MaxLabels:     config.MaxLabels,
+	}
+	policy, err := promtailconfig.NewPolicy(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return policy, nil
+}
+
+// NewHandler creates the promtail config handler shared by all controllers
+// contributing snippets to promtail's ConfigMap.
+func NewHandler(pc *promtailconfig.PromtailConfigMap, reloader promtailconfig.Reloader, observers []promtailconfig.RenderObserver,
+	logger micrologger.Logger, config LokiOperatorConfig) (promtailconfig.Handler, error) {
+	c := promtailconfig.SyncHandlerConfig{
+		Logger:            logger,
+		PromtailConfigMap: pc,
+		Reloader:          reloader,
+		RenderObservers:   observers,
+
+		MaxWait:     time.Duration(config.MaxWaitSec) * time.Second,
+		QuietPeriod: time.Duration(config.QuietPeriodSec) * time.Second,
+	}
+
+	handler, err := promtailconfig.NewSyncHandler(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return handler, nil
+}
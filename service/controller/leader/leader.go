@@ -0,0 +1,149 @@
+// Package leader elects the replica of the operator writing to the
+// Kubernetes API: promtail's ConfigMap, Events, the annotations of snippet
+// ConfigMaps and the status and finalizers of PromtailConfigs. All replicas
+// keep their informers and snippet registries warm, so a new leader takes
+// over right away.
+package leader
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+type Config struct {
+	K8sClient kubernetes.Interface
+	Logger    micrologger.Logger
+
+	// Enabled turns leader election on. Without it, the replica always
+	// leads, which is only safe with a single replica.
+	Enabled bool
+	// Namespace and Name identify the ConfigMap used as lock.
+	Namespace string
+	Name      string
+	// Identity identifies the replica, usually its pod name.
+	Identity string
+}
+
+// Elector runs the leader's work while the replica leads.
+type Elector struct {
+	logger micrologger.Logger
+
+	enabled bool
+	// leading is 1 while the replica leads, 0 otherwise. It is accessed
+	// atomically.
+	leading int32
+	lock    resourcelock.Interface
+}
+
+func New(config Config) (*Elector, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	e := &Elector{
+		logger: config.Logger,
+
+		enabled: config.Enabled,
+	}
+
+	if !config.Enabled {
+		// The replica leads from the start, so nothing it writes
+		// before Run is called gets dropped.
+		e.leading = 1
+		return e, nil
+	}
+
+	if config.Namespace == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Namespace must not be empty", config)
+	}
+	if config.Name == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Name must not be empty", config)
+	}
+	if config.Identity == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Identity must not be empty", config)
+	}
+
+	lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, config.Namespace, config.Name,
+		config.K8sClient.CoreV1(), config.K8sClient.CoordinationV1(), resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	e.lock = lock
+
+	return e, nil
+}
+
+// Run calls lead once the replica leads and returns when ctx is canceled. The
+// leader's work has to stop when the context passed to lead is canceled. When
+// the replica loses the lead without ctx being canceled, the process exits,
+// so it restarts from a clean state while another replica takes over. Run
+// returns an error right away when the election can't be started, the
+// replica would never lead otherwise.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	if !e.enabled {
+		lead(ctx)
+		return nil
+	}
+
+	c := leaderelection.LeaderElectionConfig{
+		Lock:          e.lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				e.logger.LogCtx(ctx, "level", "info", "message", "started leading")
+				atomic.StoreInt32(&e.leading, 1)
+				lead(ctx)
+			},
+			OnStoppedLeading: func() {
+				atomic.StoreInt32(&e.leading, 0)
+				if ctx.Err() != nil {
+					return
+				}
+				e.logger.LogCtx(ctx, "level", "error", "message", "lost leadership, exiting")
+				os.Exit(1)
+			},
+			OnNewLeader: func(identity string) {
+				e.logger.LogCtx(ctx, "level", "info", "message", "observed leader", "leader", identity)
+			},
+		},
+		// Releasing the lock on shutdown lets another replica take over
+		// without waiting for the lease to expire.
+		ReleaseOnCancel: true,
+		Name:            e.lock.Describe(),
+	}
+
+	elector, err := leaderelection.NewLeaderElector(c)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	elector.Run(ctx)
+
+	return nil
+}
+
+// IsLeader tells whether the replica currently leads.
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
@@ -0,0 +1,32 @@
+package namespacescope
+
+import (
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
+
+var excludedNamespaceError = &microerror.Error{
+	Kind: "excludedNamespaceError",
+}
+
+// IsExcludedNamespace asserts excludedNamespaceError.
+func IsExcludedNamespace(err error) bool {
+	return microerror.Cause(err) == excludedNamespaceError
+}
+
+var notSyncedError = &microerror.Error{
+	Kind: "notSyncedError",
+}
+
+// IsNotSynced asserts notSyncedError.
+func IsNotSynced(err error) bool {
+	return microerror.Cause(err) == notSyncedError
+}
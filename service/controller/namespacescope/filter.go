@@ -0,0 +1,84 @@
+package namespacescope
+
+import (
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type FilterConfig struct {
+	// Namespaces restricts the operator to the listed namespaces. All
+	// namespaces are included when empty.
+	Namespaces []string
+	// LabelSelector restricts the operator to the namespaces matching it.
+	// All namespaces are included when empty.
+	LabelSelector string
+}
+
+// Filter decides whether a namespace is included, from the namespace alone. It
+// needs no access to the cluster, so the render command includes the
+// namespaces of manifests the same way the Scope does the ones of the cluster.
+type Filter struct {
+	namespaces map[string]bool
+	selector   labels.Selector
+}
+
+func NewFilter(config FilterConfig) (*Filter, error) {
+	selector, err := labels.Parse(config.LabelSelector)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.LabelSelector is invalid: %v", config, err)
+	}
+
+	var namespaces map[string]bool
+	if len(config.Namespaces) > 0 {
+		namespaces = map[string]bool{}
+		for _, n := range config.Namespaces {
+			namespaces[n] = true
+		}
+	}
+
+	f := &Filter{
+		namespaces: namespaces,
+		selector:   selector,
+	}
+
+	return f, nil
+}
+
+// Namespaces returns the namespaces the operator is restricted to, in order,
+// or a single metav1.NamespaceAll when it isn't restricted.
+func (f *Filter) Namespaces() []string {
+	if f.namespaces == nil {
+		return []string{metav1.NamespaceAll}
+	}
+
+	var namespaces []string
+	for n := range f.namespaces {
+		namespaces = append(namespaces, n)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+// Check returns an error matched by IsExcludedNamespace, telling why, when ns
+// is not among the listed namespaces, doesn't match the label selector or is
+// disabled with DisabledAnnotation.
+func (f *Filter) Check(ns *v1.Namespace) error {
+	if f.namespaces != nil && !f.namespaces[ns.Name] {
+		return microerror.Maskf(excludedNamespaceError, "namespace %#q is not among the operator's namespaces", ns.Name)
+	}
+	if !f.selector.Matches(labels.Set(ns.Labels)) {
+		return microerror.Maskf(excludedNamespaceError, "namespace %#q doesn't match the operator's namespace selector %#q",
+			ns.Name, f.selector.String())
+	}
+	if ns.Annotations[DisabledAnnotation] == "true" {
+		return microerror.Maskf(excludedNamespaceError, "namespace %#q disabled with the %#q annotation", ns.Name,
+			DisabledAnnotation)
+	}
+
+	return nil
+}
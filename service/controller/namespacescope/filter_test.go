@@ -0,0 +1,119 @@
+package namespacescope
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_Filter_Check(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      FilterConfig
+		namespace   v1.Namespace
+		expectError bool
+	}{
+		{
+			name:      "case 0: namespace included without restrictions",
+			config:    FilterConfig{},
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}},
+		},
+		{
+			name: "case 1: listed namespace",
+			config: FilterConfig{
+				Namespaces: []string{"team", "ops"},
+			},
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}},
+		},
+		{
+			name: "case 2: namespace not listed",
+			config: FilterConfig{
+				Namespaces: []string{"ops"},
+			},
+			namespace:   v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}},
+			expectError: true,
+		},
+		{
+			name: "case 3: namespace matching the selector",
+			config: FilterConfig{
+				LabelSelector: "logging=enabled",
+			},
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "team",
+				Labels: map[string]string{"logging": "enabled"},
+			}},
+		},
+		{
+			name: "case 4: namespace not matching the selector",
+			config: FilterConfig{
+				LabelSelector: "logging=enabled",
+			},
+			namespace:   v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}},
+			expectError: true,
+		},
+		{
+			name:   "case 5: disabled namespace",
+			config: FilterConfig{},
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "team",
+				Annotations: map[string]string{DisabledAnnotation: "true"},
+			}},
+			expectError: true,
+		},
+		{
+			name:   "case 6: annotation not disabling the namespace",
+			config: FilterConfig{},
+			namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "team",
+				Annotations: map[string]string{DisabledAnnotation: "false"},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFilter(tc.config)
+			if err != nil {
+				t.Fatalf("expected no error creating the filter, got %#q", err)
+			}
+
+			err = f.Check(&tc.namespace)
+
+			switch {
+			case err == nil && !tc.expectError:
+				// correct; carry on
+			case err != nil && !tc.expectError:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.expectError:
+				t.Fatalf("expected error, got nil")
+			case !IsExcludedNamespace(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+		})
+	}
+}
+
+func Test_Filter_Namespaces(t *testing.T) {
+	f, err := NewFilter(FilterConfig{})
+	if err != nil {
+		t.Fatalf("expected no error creating the filter, got %#q", err)
+	}
+	if namespaces := f.Namespaces(); !reflect.DeepEqual(namespaces, []string{metav1.NamespaceAll}) {
+		t.Fatalf("expected all namespaces, got %#v", namespaces)
+	}
+
+	f, err = NewFilter(FilterConfig{Namespaces: []string{"team", "ops"}})
+	if err != nil {
+		t.Fatalf("expected no error creating the filter, got %#q", err)
+	}
+	if namespaces := f.Namespaces(); !reflect.DeepEqual(namespaces, []string{"ops", "team"}) {
+		t.Fatalf("expected the listed namespaces in order, got %#v", namespaces)
+	}
+
+	_, err = NewFilter(FilterConfig{LabelSelector: "logging in enabled"})
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error, got %#v", err)
+	}
+}
@@ -0,0 +1,284 @@
+// Package namespacescope tells the namespaces whose pods and PromtailConfigs
+// contribute to promtail's config. The operator can be restricted to a list
+// of namespaces and to the namespaces matching a label selector, and every
+// namespace can opt out with DisabledAnnotation. Restricted to a list of
+// namespaces, the operator only lists and watches the objects of the listed
+// namespaces, one informer per namespace, so it needs no RBAC permissions on
+// other namespaces.
+package namespacescope
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DisabledAnnotation disables the snippets of all the pods and
+	// PromtailConfigs of the annotated namespace, when set to "true".
+	DisabledAnnotation = "giantswarm.io/loki-promtail-disabled"
+
+	// resyncPeriod is zero, because the namespaces are only looked up in
+	// the informer's cache.
+	resyncPeriod = 0
+)
+
+type Config struct {
+	K8sClient kubernetes.Interface
+	Logger    micrologger.Logger
+
+	// Namespaces restricts the operator to the listed namespaces. All
+	// namespaces are included when empty.
+	Namespaces []string
+	// LabelSelector restricts the operator to the namespaces matching it.
+	// All namespaces are included when empty.
+	LabelSelector string
+}
+
+// Scope decides whether a namespace is included, based on a cache of the
+// namespaces it watches. Listeners are told when namespaces are included or
+// excluded.
+type Scope struct {
+	logger micrologger.Logger
+
+	// informers maps the listed namespaces to the informers watching them.
+	// Without a list, it holds a single informer watching all namespaces
+	// under metav1.NamespaceAll.
+	informers map[string]cache.SharedIndexInformer
+	filter    *Filter
+
+	// mutex guards listeners and namespaceListeners.
+	mutex              sync.Mutex
+	listeners          []func()
+	namespaceListeners []func(oldNamespace, newNamespace *v1.Namespace)
+}
+
+func New(config Config) (*Scope, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	filter, err := NewFilter(FilterConfig{
+		Namespaces:    config.Namespaces,
+		LabelSelector: config.LabelSelector,
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	s := &Scope{
+		logger: config.Logger,
+
+		informers: map[string]cache.SharedIndexInformer{},
+		filter:    filter,
+	}
+
+	// Namespaces are watched without the label selector, so the ones
+	// losing the labels are noticed.
+	for _, namespace := range s.Namespaces() {
+		var fieldSelector fields.Selector
+		if namespace != metav1.NamespaceAll {
+			fieldSelector = fields.OneTermEqualSelector("metadata.name", namespace)
+		} else {
+			fieldSelector = fields.Everything()
+		}
+		lw := cache.NewListWatchFromClient(config.K8sClient.CoreV1().RESTClient(), "namespaces", "", fieldSelector)
+
+		informer := cache.NewSharedIndexInformer(lw, &v1.Namespace{}, resyncPeriod, cache.Indexers{})
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				s.changed(nil, obj)
+			},
+			UpdateFunc: s.changed,
+			DeleteFunc: func(obj interface{}) {
+				s.changed(obj, nil)
+			},
+		})
+		s.informers[namespace] = informer
+	}
+
+	return s, nil
+}
+
+// Boot runs the namespace informers until ctx is canceled.
+func (s *Scope) Boot(ctx context.Context) {
+	for _, informer := range s.informers {
+		go informer.Run(ctx.Done())
+	}
+
+	<-ctx.Done()
+}
+
+// WaitForCacheSync blocks until all namespaces are known. It returns false
+// when ctx is canceled before.
+func (s *Scope) WaitForCacheSync(ctx context.Context) bool {
+	return cache.WaitForCacheSync(ctx.Done(), s.hasSynced)
+}
+
+// Namespaces returns the namespaces the operator is restricted to, in order,
+// or a single metav1.NamespaceAll when it isn't restricted. Namespaced
+// objects are listed and watched once for every namespace returned.
+func (s *Scope) Namespaces() []string {
+	return s.filter.Namespaces()
+}
+
+// OnChange registers f to be called whenever a namespace gets included or
+// excluded. f must not block.
+func (s *Scope) OnChange(f func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.listeners = append(s.listeners, f)
+}
+
+// OnNamespaceChange registers f to be called whenever a namespace is added,
+// changed or deleted after all namespaces were listed. Either namespace is nil
+// when the namespace was added or deleted. f must not block.
+func (s *Scope) OnNamespaceChange(f func(oldNamespace, newNamespace *v1.Namespace)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.namespaceListeners = append(s.namespaceListeners, f)
+}
+
+// Get returns the namespace from the cache. It returns false when the
+// namespace isn't known.
+func (s *Scope) Get(name string) (*v1.Namespace, bool) {
+	informer, found := s.informer(name)
+	if !found {
+		return nil, false
+	}
+	obj, exists, err := informer.GetIndexer().GetByKey(name)
+	if err != nil || !exists {
+		return nil, false
+	}
+	ns, ok := obj.(*v1.Namespace)
+
+	return ns, ok
+}
+
+// Check returns an error matched by IsExcludedNamespace, telling why, when
+// namespace is excluded. Namespaces not known yet are excluded. Before the
+// namespaces are listed it returns an error matched by IsNotSynced.
+func (s *Scope) Check(namespace string) error {
+	if !s.hasSynced() {
+		return microerror.Maskf(notSyncedError, "namespaces not listed yet")
+	}
+
+	informer, found := s.informer(namespace)
+	if !found {
+		return microerror.Maskf(excludedNamespaceError, "namespace %#q is not among the operator's namespaces", namespace)
+	}
+	obj, exists, err := informer.GetIndexer().GetByKey(namespace)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !exists {
+		return microerror.Maskf(excludedNamespaceError, "namespace %#q not found", namespace)
+	}
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		return microerror.Maskf(excludedNamespaceError, "namespace %#q not found", namespace)
+	}
+
+	return s.filter.Check(ns)
+}
+
+// changed passes the change of a namespace from oldObj to newObj to the
+// namespace listeners and tells the listeners when it included or excluded
+// the namespace. Either may be nil.
+func (s *Scope) changed(oldObj, newObj interface{}) {
+	// The namespaces listed initially are picked up by WaitForCacheSync.
+	if !s.hasSynced() {
+		return
+	}
+
+	oldNamespace := namespace(oldObj)
+	newNamespace := namespace(newObj)
+
+	s.mutex.Lock()
+	namespaceListeners := s.namespaceListeners
+	s.mutex.Unlock()
+
+	for _, f := range namespaceListeners {
+		f(oldNamespace, newNamespace)
+	}
+
+	wasIncluded, name := s.included(oldNamespace)
+	isIncluded, newName := s.included(newNamespace)
+	if name == "" {
+		name = newName
+	}
+	if wasIncluded == isIncluded {
+		return
+	}
+
+	if isIncluded {
+		s.logger.Log("level", "info", "message", fmt.Sprintf("namespace %#q included", name))
+	} else {
+		s.logger.Log("level", "info", "message", fmt.Sprintf("namespace %#q excluded", name))
+	}
+
+	s.mutex.Lock()
+	listeners := s.listeners
+	s.mutex.Unlock()
+
+	for _, f := range listeners {
+		f()
+	}
+}
+
+// informer returns the informer watching namespace.
+func (s *Scope) informer(namespace string) (cache.SharedIndexInformer, bool) {
+	if s.filter.namespaces == nil {
+		namespace = metav1.NamespaceAll
+	}
+	informer, found := s.informers[namespace]
+
+	return informer, found
+}
+
+// hasSynced tells whether all the namespace informers listed their
+// namespaces.
+func (s *Scope) hasSynced() bool {
+	for _, informer := range s.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Scope) included(ns *v1.Namespace) (bool, string) {
+	if ns == nil {
+		return false, ""
+	}
+
+	return s.filter.Check(ns) == nil, ns.Name
+}
+
+// namespace returns the namespace held by obj, which may be a tombstone, or
+// nil.
+func namespace(obj interface{}) *v1.Namespace {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		return nil
+	}
+
+	return ns
+}
@@ -1,4 +1,4 @@
-package test
+package podconfig
 
 import (
 	"github.com/giantswarm/microerror"
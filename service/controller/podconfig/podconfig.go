@@ -0,0 +1,218 @@
+// Package podconfig resolves the logging containers of pods to the Keys and
+// the ConfigMaps of their promtail snippets.
+package podconfig
+
+import (
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/giantswarm/loki-operator/service/controller/events"
+	"github.com/giantswarm/loki-operator/service/controller/leader"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+const (
+	PromtailConfigLabel        = "giantswarm.io/loki-promtail-config"
+	PromtailContainerNameLabel = "giantswarm.io/loki-promtail-container"
+	// PromtailContainersAnnotation maps logging containers of a pod to the
+	// ConfigMaps holding their snippets, e.g.
+	// "app=app-promtail-config,istio-proxy=mesh-promtail-config:istio.yaml".
+	PromtailContainersAnnotation = "giantswarm.io/loki-promtail-containers"
+	PromtailConfigMapKeyName     = "promtail.yaml"
+)
+
+type Config struct {
+	K8sClient     kubernetes.Interface
+	KeyResolver   *promtailconfig.KeyResolver
+	LeaderElector *leader.Elector
+	Recorder      *events.Recorder
+}
+
+// Resolver tells which containers of a pod are logging containers and where
+// their snippets come from.
+type Resolver struct {
+	k8sClient     kubernetes.Interface
+	keyResolver   *promtailconfig.KeyResolver
+	leaderElector *leader.Elector
+	recorder      *events.Recorder
+}
+
+func New(config Config) (*Resolver, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.KeyResolver == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.KeyResolver must not be empty", config)
+	}
+	if config.LeaderElector == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.LeaderElector must not be empty", config)
+	}
+	if config.Recorder == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Recorder must not be empty", config)
+	}
+
+	r := &Resolver{
+		k8sClient:     config.K8sClient,
+		keyResolver:   config.KeyResolver,
+		leaderElector: config.LeaderElector,
+		recorder:      config.Recorder,
+	}
+
+	return r, nil
+}
+
+// ContainerConfig binds a logging container of a pod to the ConfigMap and the
+// key within it, which hold the promtail snippet for the container.
+type ContainerConfig struct {
+	Key           promtailconfig.Key
+	ConfigMapName string
+	ConfigMapKey  string
+}
+
+// ContainerConfigs returns the configs of all the logging containers of the
+// pod. See ContainerConfigs.
+func (r *Resolver) ContainerConfigs(pod *v1.Pod) ([]ContainerConfig, error) {
+	configs, err := ContainerConfigs(r.keyResolver, pod)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return configs, nil
+}
+
+// ContainerConfigs returns the configs of all the logging containers of the
+// pod, with Keys built by keyResolver. Pods annotated with
+// PromtailContainersAnnotation get one config for every container listed in
+// the annotation. Otherwise the pod has a single logging container, which gets
+// its snippet from the ConfigMap named by PromtailConfigLabel.
+func ContainerConfigs(keyResolver *promtailconfig.KeyResolver, pod *v1.Pod) ([]ContainerConfig, error) {
+	configMapName, found := pod.ObjectMeta.Labels[PromtailConfigLabel]
+	if !found {
+		return nil, microerror.Maskf(invalidDynamicConfigError, "Pod %s/%s doesn't have %s Label", pod.Namespace,
+			pod.Name, PromtailConfigLabel)
+	}
+
+	annotation, found := pod.ObjectMeta.Annotations[PromtailContainersAnnotation]
+	if !found {
+		key, err := configKeyName(keyResolver, pod)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		configs := []ContainerConfig{
+			{
+				Key:           *key,
+				ConfigMapName: configMapName,
+				ConfigMapKey:  PromtailConfigMapKeyName,
+			},
+		}
+		return configs, nil
+	}
+
+	var configs []ContainerConfig
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(annotation, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, microerror.Maskf(invalidDynamicConfigError, "Invalid entry '%s' in '%s' Annotation of Pod %s/%s,"+
+				" expected 'container=configmap[:key]'", entry, PromtailContainersAnnotation, pod.Namespace, pod.Name)
+		}
+		containerName := parts[0]
+		if seen[containerName] {
+			return nil, microerror.Maskf(invalidDynamicConfigError, "Container %v configured more than once in '%s' "+
+				"Annotation of Pod %s/%s", containerName, PromtailContainersAnnotation, pod.Namespace, pod.Name)
+		}
+		seen[containerName] = true
+		if !hasContainer(pod, containerName) {
+			return nil, microerror.Maskf(invalidDynamicConfigError, "Container %v not found in pod %v, but "+
+				"configured as a logging container of the pod using '%s' Annotation", containerName,
+				pod.ObjectMeta.Name, PromtailContainersAnnotation)
+		}
+
+		key, err := keyResolver.NewKey(pod, containerName)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		c := ContainerConfig{
+			Key:           *key,
+			ConfigMapName: parts[1],
+			ConfigMapKey:  PromtailConfigMapKeyName,
+		}
+		if i := strings.Index(parts[1], ":"); i >= 0 {
+			c.ConfigMapName = parts[1][:i]
+			c.ConfigMapKey = parts[1][i+1:]
+		}
+		if c.ConfigMapName == "" {
+			c.ConfigMapName = configMapName
+		}
+		if c.ConfigMapKey == "" {
+			return nil, microerror.Maskf(invalidDynamicConfigError, "Invalid entry '%s' in '%s' Annotation of Pod %s/%s,"+
+				" ConfigMap key must not be empty", entry, PromtailContainersAnnotation, pod.Namespace, pod.Name)
+		}
+		configs = append(configs, c)
+	}
+
+	if len(configs) == 0 {
+		return nil, microerror.Maskf(invalidDynamicConfigError, "No containers configured in '%s' Annotation of "+
+			"Pod %s/%s", PromtailContainersAnnotation, pod.Namespace, pod.Name)
+	}
+
+	return configs, nil
+}
+
+func configKeyName(keyResolver *promtailconfig.KeyResolver, pod *v1.Pod) (*promtailconfig.Key, error) {
+	containerName, found := pod.ObjectMeta.Labels[PromtailContainerNameLabel]
+	if !found {
+		if len(pod.Spec.Containers) != 1 {
+			return nil, microerror.Maskf(invalidDynamicConfigError, "More than one container running in the Pod %s,"+
+				" but no single logging container configure with '%s' Label or logging containers configured "+
+				"with '%s' Annotation", pod.Name, PromtailContainerNameLabel, PromtailContainersAnnotation)
+		}
+		containerName = pod.Spec.Containers[0].Name
+	} else {
+		if !hasContainer(pod, containerName) {
+			return nil, microerror.Maskf(invalidDynamicConfigError, "Container %v not found in pod %v, but "+
+				"configured as the logging container of the pod using '%s' Label", containerName, pod.ObjectMeta.Name,
+				PromtailContainerNameLabel)
+		}
+	}
+
+	key, err := keyResolver.NewKey(pod, containerName)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return key, nil
+}
+
+// LoadSnippet reads the snippet of config from its ConfigMap in namespace.
+func (r *Resolver) LoadSnippet(namespace string, config ContainerConfig) (string, error) {
+	name := config.ConfigMapName
+	cm, err := r.k8sClient.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", microerror.Maskf(invalidDynamicConfigError, "Promtail ConfigMap named '%s' configured, but not found: %v",
+			name, err)
+	}
+	cfgTxt, found := cm.Data[config.ConfigMapKey]
+	if !found {
+		return "", microerror.Maskf(invalidDynamicConfigError, "'%s' key not found in ConfigMap named '%v' configured",
+			config.ConfigMapKey, name)
+	}
+	return cfgTxt, nil
+}
+
+func hasContainer(pod *v1.Pod, containerName string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == containerName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package podconfig
+
+import (
+	"encoding/json"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/giantswarm/loki-operator/service/controller/events"
+)
+
+const (
+	// RejectedSnippetsAnnotation is put on snippet ConfigMaps holding rejected
+	// snippets. It maps the keys of the rejected snippets to the reasons,
+	// e.g. {"promtail.yaml":"pipeline stage 1 (regex): expression must not be empty"}.
+	RejectedSnippetsAnnotation = "giantswarm.io/loki-promtail-rejected"
+)
+
+// ReportSnippet records on the snippet ConfigMap why the snippet of config was
+// rejected. A nil rejection clears the former one. The ConfigMap is only
+// written when the recorded reasons change, which is also when an Event is
+// emitted on it. Only the leader reports, on the other replicas it does
+// nothing.
+func (r *Resolver) ReportSnippet(namespace string, config ContainerConfig, rejection error) error {
+	if !r.leaderElector.IsLeader() {
+		return nil
+	}
+
+	var updated *v1.ConfigMap
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := r.k8sClient.CoreV1().ConfigMaps(namespace).Get(config.ConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		reasons := map[string]string{}
+		if annotation, found := cm.Annotations[RejectedSnippetsAnnotation]; found {
+			// A broken annotation is replaced.
+			_ = json.Unmarshal([]byte(annotation), &reasons)
+		}
+
+		if rejection == nil {
+			if _, found := reasons[config.ConfigMapKey]; !found {
+				return nil
+			}
+			delete(reasons, config.ConfigMapKey)
+		} else {
+			if reasons[config.ConfigMapKey] == rejection.Error() {
+				return nil
+			}
+			reasons[config.ConfigMapKey] = rejection.Error()
+		}
+
+		if len(reasons) == 0 {
+			delete(cm.Annotations, RejectedSnippetsAnnotation)
+		} else {
+			b, err := json.Marshal(reasons)
+			if err != nil {
+				return err
+			}
+			if cm.Annotations == nil {
+				cm.Annotations = map[string]string{}
+			}
+			cm.Annotations[RejectedSnippetsAnnotation] = string(b)
+		}
+
+		updated, err = r.k8sClient.CoreV1().ConfigMaps(namespace).Update(cm)
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if updated != nil {
+		if rejection == nil {
+			r.recorder.Normal(updated, events.ReasonSnippetAccepted, "snippet in key %#q accepted", config.ConfigMapKey)
+		} else {
+			r.recorder.Warning(updated, events.ReasonSnippetRejected, "snippet in key %#q rejected: %s", config.ConfigMapKey,
+				rejection.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package podwatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/giantswarm/loki-operator/pkg/project"
+)
+
+const (
+	// migrationRetryInterval is the time to wait before retrying to remove
+	// the legacy finalizers.
+	migrationRetryInterval = time.Minute
+	// migrationPageSize is the number of pods listed at once.
+	migrationPageSize = 500
+)
+
+// legacyFinalizer is the finalizer operatorkit put on pods, when pods were
+// watched by an operatorkit controller.
+var legacyFinalizer = fmt.Sprintf("operatorkit.giantswarm.io/%s-todo-controller", project.Name())
+
+// RemoveLegacyFinalizers removes legacyFinalizer from all pods. Pods which lost
+// the promtail label still may carry it, so all pods are checked. Former
+// versions watched all namespaces, so the pods of namespaces outside the
+// operator's scope are checked as well. It retries until it succeeds or ctx
+// is canceled. Only the leader runs it.
+func (w *Watcher) RemoveLegacyFinalizers(ctx context.Context) {
+	_ = wait.PollImmediateUntil(migrationRetryInterval, func() (bool, error) {
+		err := w.removeLegacyFinalizersOnce(ctx)
+		if err != nil {
+			w.logger.LogCtx(ctx, "level", "error", "message", "failed to remove legacy finalizers from pods",
+				"stack", microerror.Stack(err))
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+}
+
+func (w *Watcher) removeLegacyFinalizersOnce(ctx context.Context) error {
+	w.logger.LogCtx(ctx, "level", "debug", "message", "removing legacy finalizers from pods")
+
+	removed := 0
+	options := metav1.ListOptions{
+		Limit: migrationPageSize,
+	}
+	for {
+		pods, err := w.k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(options)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !hasFinalizer(pod, legacyFinalizer) {
+				continue
+			}
+
+			err = w.removeLegacyFinalizer(pod.Namespace, pod.Name)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+			removed++
+		}
+
+		if pods.Continue == "" {
+			break
+		}
+		options.Continue = pods.Continue
+	}
+
+	w.logger.LogCtx(ctx, "level", "debug", "message", fmt.Sprintf("removed legacy finalizers from %d pods", removed))
+
+	return nil
+}
+
+func (w *Watcher) removeLegacyFinalizer(namespace, name string) error {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		pod, err := w.k8sClient.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		var finalizers []string
+		for _, f := range pod.Finalizers {
+			if f != legacyFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		if len(finalizers) == len(pod.Finalizers) {
+			return nil
+		}
+		pod.Finalizers = finalizers
+
+		_, err = w.k8sClient.CoreV1().Pods(namespace).Update(pod)
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func hasFinalizer(pod *v1.Pod, finalizer string) bool {
+	for _, f := range pod.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+// Package podwatcher registers the promtail snippets of pods carrying the
+// promtail label. It watches pods with a shared informer and, unlike an
+// operatorkit controller, never puts finalizers on them, so pods are never
+// kept from terminating when the operator isn't running. Deletions missed
+// while the operator was down are cleaned up by the resync.
+package podwatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/giantswarm/loki-operator/service/controller/events"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+	"github.com/giantswarm/loki-operator/service/controller/snippetwatcher"
+)
+
+const (
+	// resyncPeriod is zero, because all pods are periodically resynced by
+	// the resync package.
+	resyncPeriod = 0
+)
+
+type Config struct {
+	K8sClient      kubernetes.Interface
+	Logger         micrologger.Logger
+	Handler        promtailconfig.Handler
+	PodConfig      *podconfig.Resolver
+	Recorder       *events.Recorder
+	Scope          *namespacescope.Scope
+	SnippetWatcher *snippetwatcher.Watcher
+}
+
+// Watcher registers the snippets of the pods carrying the promtail label when
+// they are created or changed and retracts them when the pods are deleted.
+type Watcher struct {
+	k8sClient      kubernetes.Interface
+	logger         micrologger.Logger
+	handler        promtailconfig.Handler
+	podConfig      *podconfig.Resolver
+	recorder       *events.Recorder
+	scope          *namespacescope.Scope
+	snippetWatcher *snippetwatcher.Watcher
+
+	// informers maps the namespaces of the operator's scope to the
+	// informers watching their pods, see namespacescope.Scope.Namespaces.
+	informers map[string]cache.SharedIndexInformer
+	queue     workqueue.RateLimitingInterface
+}
+
+func New(config Config) (*Watcher, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Handler == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Handler must not be empty", config)
+	}
+	if config.PodConfig == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PodConfig must not be empty", config)
+	}
+	if config.Recorder == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Recorder must not be empty", config)
+	}
+	if config.Scope == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Scope must not be empty", config)
+	}
+	if config.SnippetWatcher == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.SnippetWatcher must not be empty", config)
+	}
+
+	w := &Watcher{
+		k8sClient:      config.K8sClient,
+		logger:         config.Logger,
+		handler:        config.Handler,
+		podConfig:      config.PodConfig,
+		recorder:       config.Recorder,
+		scope:          config.Scope,
+		snippetWatcher: config.SnippetWatcher,
+
+		informers: map[string]cache.SharedIndexInformer{},
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pods"),
+	}
+
+	for _, namespace := range w.scope.Namespaces() {
+		// Pods losing the label are deleted from the informer's point of
+		// view, so their snippets are retracted.
+		lw := cache.NewFilteredListWatchFromClient(config.K8sClient.CoreV1().RESTClient(), "pods", namespace,
+			func(options *metav1.ListOptions) {
+				options.LabelSelector = podconfig.PromtailConfigLabel
+			})
+
+		informer := cache.NewSharedIndexInformer(lw, &v1.Pod{}, resyncPeriod, cache.Indexers{})
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    w.enqueue,
+			UpdateFunc: w.updateFunc,
+			DeleteFunc: w.deleteFunc,
+		})
+		w.informers[namespace] = informer
+	}
+
+	return w, nil
+}
+
+// Boot runs the pod informers until ctx is canceled.
+func (w *Watcher) Boot(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		w.queue.ShutDown()
+	}()
+
+	var hasSynced []cache.InformerSynced
+	for _, informer := range w.informers {
+		go informer.Run(ctx.Done())
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+		return
+	}
+	if !w.scope.WaitForCacheSync(ctx) {
+		return
+	}
+
+	wait.UntilWithContext(ctx, w.runWorker, 0)
+}
+
+func (w *Watcher) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		w.logger.Log("level", "error", "message", "failed to compute key of pod", "stack", microerror.Stack(err))
+		return
+	}
+
+	w.queue.Add(key)
+}
+
+func (w *Watcher) updateFunc(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	newPod, ok := newObj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	if oldPod.ResourceVersion == newPod.ResourceVersion {
+		return
+	}
+
+	w.enqueue(newPod)
+}
+
+func (w *Watcher) deleteFunc(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		pod, ok = tombstone.Obj.(*v1.Pod)
+		if !ok {
+			return
+		}
+	}
+
+	w.unregister(pod)
+}
+
+func (w *Watcher) runWorker(ctx context.Context) {
+	for w.processNextItem(ctx) {
+	}
+}
+
+func (w *Watcher) processNextItem(ctx context.Context) bool {
+	item, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(item)
+
+	err := w.sync(ctx, item.(string))
+	if err != nil {
+		w.logger.LogCtx(ctx, "level", "error", "message", fmt.Sprintf("failed to register snippets of pod %#q", item),
+			"stack", microerror.Stack(err))
+		w.queue.AddRateLimited(item)
+		return true
+	}
+
+	w.queue.Forget(item)
+	return true
+}
+
+// sync registers the snippets of the pod identified by key. Pods gone in the
+// meantime were already unregistered by the delete event.
+func (w *Watcher) sync(ctx context.Context, key string) error {
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	informer, found := w.informers[namespace]
+	if !found {
+		informer, found = w.informers[metav1.NamespaceAll]
+	}
+	if !found {
+		return nil
+	}
+
+	obj, exists, err := informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if !exists {
+		return nil
+	}
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil
+	}
+
+	if pod.DeletionTimestamp != nil {
+		w.unregister(pod)
+		return nil
+	}
+
+	// Pods of excluded namespaces are registered by the resync once their
+	// namespace gets included.
+	err = w.scope.Check(pod.Namespace)
+	if namespacescope.IsExcludedNamespace(err) {
+		w.logger.LogCtx(ctx, "level", "debug", "message", fmt.Sprintf("ignoring pod %#q", key), "reason", err.Error())
+		w.unregister(pod)
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return w.register(ctx, pod)
+}
+
+func (w *Watcher) register(ctx context.Context, pod *v1.Pod) error {
+	configs, err := w.podConfig.ContainerConfigs(pod)
+	if podconfig.IsInvalidDynamicConfig(err) {
+		// The snippets registered for the former labels and annotations of
+		// the pod don't apply anymore.
+		w.recorder.Warning(pod, events.ReasonInvalidPodConfig, "%s", err.Error())
+		w.unregister(pod)
+		return microerror.Mask(err)
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	w.recorder.Watch(pod)
+
+	// Changed labels or annotations of the pod may change its Keys or the
+	// containers it configures, so the snippets of Keys the pod doesn't
+	// resolve to anymore are retracted.
+	var keys []promtailconfig.Key
+	for _, c := range configs {
+		keys = append(keys, c.Key)
+	}
+	w.snippetWatcher.Retain(pod.UID, keys)
+	w.handler.RetainConfigs(pod.UID, keys)
+
+	var loadErr error
+	for _, c := range configs {
+		// Track the ConfigMap before loading it, so changes made in between
+		// are pushed by the snippet watcher.
+		w.snippetWatcher.Track(pod.Namespace, c.ConfigMapName, snippetwatcher.Dependent{
+			Key:          c.Key,
+			Source:       pod.UID,
+			ConfigMapKey: c.ConfigMapKey,
+		})
+
+		cfgTxt, err := w.podConfig.LoadSnippet(pod.Namespace, c)
+		if err != nil {
+			w.recorder.Warning(pod, events.ReasonSnippetNotFound, "snippet of container %#q not loaded: %s",
+				c.Key.ContainerName, err.Error())
+			// Keep registering the remaining containers, the error makes
+			// sure the pod is synced again.
+			loadErr = err
+			continue
+		}
+		err = w.handler.AddConfig(c.Key, pod.UID, cfgTxt)
+		if promtailconfig.IsInvalidSnippet(err) {
+			// A broken snippet doesn't get better by retrying, it is
+			// registered once its ConfigMap is fixed.
+			w.logger.LogCtx(ctx, "level", "warning", "message", fmt.Sprintf("rejected snippet of container %#q", c.Key.ContainerName),
+				"reason", err.Error())
+			w.recorder.Warning(pod, events.ReasonSnippetRejected, "snippet of container %#q rejected: %s",
+				c.Key.ContainerName, err.Error())
+			w.report(ctx, pod.Namespace, c, err)
+		} else if promtailconfig.IsSnippetConflict(err) {
+			// The snippet is registered nonetheless.
+			w.logger.LogCtx(ctx, "level", "warning", "message", "pods with the same key use different snippets",
+				"reason", err.Error())
+			w.recorder.Warning(pod, events.ReasonSnippetConflict, "snippet of container %#q differs from the ones of "+
+				"other pods of the workload, the snippet registered last is rendered", c.Key.ContainerName)
+			w.report(ctx, pod.Namespace, c, nil)
+		} else if err != nil {
+			return microerror.Mask(err)
+		} else {
+			w.report(ctx, pod.Namespace, c, nil)
+		}
+	}
+
+	return loadErr
+}
+
+// report attaches the reason a snippet was rejected to its ConfigMap, or
+// clears it when the snippet was accepted. Failing to do so doesn't affect
+// the registration.
+func (w *Watcher) report(ctx context.Context, namespace string, c podconfig.ContainerConfig, rejection error) {
+	err := w.podConfig.ReportSnippet(namespace, c, rejection)
+	if err != nil {
+		w.logger.LogCtx(ctx, "level", "warning", "message", "failed to report snippet status on ConfigMap",
+			"configmap", fmt.Sprintf("%s/%s", namespace, c.ConfigMapName), "reason", err.Error())
+	}
+}
+
+// unregister retracts all the snippets of pod. It relies on the UID only, as
+// the Keys of a pod being deleted can't be resolved anymore once its owners
+// are gone.
+func (w *Watcher) unregister(pod *v1.Pod) {
+	w.snippetWatcher.Untrack(pod.UID)
+	w.recorder.Forget(pod.UID)
+	w.handler.DelSource(pod.UID)
+}
@@ -5,26 +5,31 @@ import (
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/micrologger"
 	"github.com/giantswarm/operatorkit/controller"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
 	"github.com/giantswarm/loki-operator/pkg/project"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+	"github.com/giantswarm/loki-operator/service/controller/resource/promtailconfigcr"
 )
 
-type TODOConfig struct {
+type PromtailConfigConfig struct {
 	K8sClient k8sclient.Interface
 	Logger    micrologger.Logger
-	Loki      LokiOperatorConfig
+	Handler   promtailconfig.Handler
+	Scope     *namespacescope.Scope
+	Tracker   *promtailconfigcr.Tracker
 }
 
-type TODO struct {
+type PromtailConfig struct {
 	*controller.Controller
 }
 
-func NewTODO(config TODOConfig) (*TODO, error) {
+func NewPromtailConfig(config PromtailConfigConfig) (*PromtailConfig, error) {
 	var err error
 
-	resourceSets, err := newTODOResourceSets(config)
+	resourceSets, err := newPromtailConfigResourceSets(config)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
@@ -32,18 +37,17 @@ func NewTODO(config TODOConfig) (*TODO, error) {
 	var operatorkitController *controller.Controller
 	{
 		c := controller.Config{
-			// If your operator watches a CRD add it here.
-			// CRD:       v1alpha1.NewAppCRD(),
+			CRD:          v1alpha1.NewPromtailConfigCRD(),
 			K8sClient:    config.K8sClient,
 			Logger:       config.Logger,
 			ResourceSets: resourceSets,
 			NewRuntimeObjectFunc: func() runtime.Object {
-				return new(corev1.Pod)
+				return new(v1alpha1.PromtailConfig)
 			},
 
 			// Name is used to compute finalizer names. This here results in something
-			// like operatorkit.giantswarm.io/loki-operator-todo-controller.
-			Name: project.Name() + "-todo-controller",
+			// like operatorkit.giantswarm.io/loki-operator-promtail-config-controller.
+			Name: project.Name() + "-promtail-config-controller",
 		}
 
 		operatorkitController, err = controller.New(c)
@@ -52,25 +56,27 @@ func NewTODO(config TODOConfig) (*TODO, error) {
 		}
 	}
 
-	c := &TODO{
+	c := &PromtailConfig{
 		Controller: operatorkitController,
 	}
 
 	return c, nil
 }
 
-func newTODOResourceSets(config TODOConfig) ([]*controller.ResourceSet, error) {
+func newPromtailConfigResourceSets(config PromtailConfigConfig) ([]*controller.ResourceSet, error) {
 	var err error
 
 	var resourceSet *controller.ResourceSet
 	{
-		c := todoResourceSetConfig{
+		c := promtailConfigResourceSetConfig{
 			K8sClient: config.K8sClient,
 			Logger:    config.Logger,
-			Loki:      config.Loki,
+			Handler:   config.Handler,
+			Scope:     config.Scope,
+			Tracker:   config.Tracker,
 		}
 
-		resourceSet, err = newTODOResourceSet(c)
+		resourceSet, err = newPromtailConfigResourceSet(c)
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
@@ -8,57 +8,42 @@ import (
 	"github.com/giantswarm/operatorkit/resource"
 	"github.com/giantswarm/operatorkit/resource/wrapper/metricsresource"
 	"github.com/giantswarm/operatorkit/resource/wrapper/retryresource"
-	v1 "k8s.io/api/core/v1"
-	"time"
 
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
 	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
-	"github.com/giantswarm/loki-operator/service/controller/resource/test"
+	"github.com/giantswarm/loki-operator/service/controller/resource/promtailconfigcr"
 )
 
-type LokiOperatorConfig struct {
-	PromtailConfigmapNamespace string
-	PromtailConfigmapName      string
-	InitialDelaySec            int
-	PeriodSec                  int
-}
-
-type todoResourceSetConfig struct {
+type promtailConfigResourceSetConfig struct {
 	K8sClient k8sclient.Interface
 	Logger    micrologger.Logger
 	Handler   promtailconfig.Handler
-	Loki      LokiOperatorConfig
+	Scope     *namespacescope.Scope
+	Tracker   *promtailconfigcr.Tracker
 }
 
-func newTODOResourceSet(config todoResourceSetConfig) (*controller.ResourceSet, error) {
+func newPromtailConfigResourceSet(config promtailConfigResourceSetConfig) (*controller.ResourceSet, error) {
 	var err error
 
-	var testResource resource.Interface
+	var promtailConfigCRResource resource.Interface
 	{
-		pc, err := promtailconfig.NewPromtailConfigMap(config.K8sClient, config.Loki.PromtailConfigmapNamespace,
-			config.Loki.PromtailConfigmapName, test.PromtailConfigMapKeyName)
-		if err != nil {
-			return nil, err
-		}
-		handler, err := promtailconfig.NewPeriodicHandler(time.Duration(config.Loki.InitialDelaySec)*time.Second,
-			time.Duration(config.Loki.PeriodSec)*time.Second, pc)
-		if err != nil {
-			return nil, microerror.Mask(err)
-		}
-
-		c := test.Config{
+		c := promtailconfigcr.Config{
 			K8sClient: config.K8sClient,
 			Logger:    config.Logger,
-			Handler:   handler,
+			Handler:   config.Handler,
+			Scope:     config.Scope,
+			Tracker:   config.Tracker,
 		}
 
-		testResource, err = test.New(c)
+		promtailConfigCRResource, err = promtailconfigcr.New(c)
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
 	}
 
 	resources := []resource.Interface{
-		testResource,
+		promtailConfigCRResource,
 	}
 
 	{
@@ -82,15 +67,8 @@ func newTODOResourceSet(config todoResourceSetConfig) (*controller.ResourceSet,
 	}
 
 	handlesFunc := func(obj interface{}) bool {
-		pod, castOk := obj.(*v1.Pod)
-		if !castOk {
-			return false
-		}
-		_, found := pod.ObjectMeta.Labels[test.PromtailConfigLabel]
-		if !found {
-			return false
-		}
-		return true
+		_, castOk := obj.(*v1alpha1.PromtailConfig)
+		return castOk
 	}
 
 	var resourceSet *controller.ResourceSet
@@ -0,0 +1,68 @@
+package promtailconfig
+
+import (
+	"github.com/giantswarm/microerror"
+)
+
+var invalidKeyError = &microerror.Error{
+	Kind: "invalidKeyError",
+}
+
+// IsInvalidKey asserts invalidKeyError.
+func IsInvalidKey(err error) bool {
+	return microerror.Cause(err) == invalidKeyError
+}
+
+var snippetConflictError = &microerror.Error{
+	Kind: "snippetConflictError",
+}
+
+// IsSnippetConflict asserts snippetConflictError.
+func IsSnippetConflict(err error) bool {
+	return microerror.Cause(err) == snippetConflictError
+}
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
+
+var unsupportedOwnerError = &microerror.Error{
+	Kind: "unsupportedOwnerError",
+}
+
+// IsUnsupportedOwner asserts unsupportedOwnerError.
+func IsUnsupportedOwner(err error) bool {
+	return microerror.Cause(err) == unsupportedOwnerError
+}
+
+var invalidSnippetError = &microerror.Error{
+	Kind: "invalidSnippetError",
+}
+
+// IsInvalidSnippet asserts invalidSnippetError.
+func IsInvalidSnippet(err error) bool {
+	return microerror.Cause(err) == invalidSnippetError
+}
+
+var invalidStateError = &microerror.Error{
+	Kind: "invalidStateError",
+}
+
+// IsInvalidState asserts invalidStateError.
+func IsInvalidState(err error) bool {
+	return microerror.Cause(err) == invalidStateError
+}
+
+var baseConfigMissingError = &microerror.Error{
+	Kind: "baseConfigMissingError",
+}
+
+// IsBaseConfigMissing asserts baseConfigMissingError.
+func IsBaseConfigMissing(err error) bool {
+	return microerror.Cause(err) == baseConfigMissingError
+}
@@ -1,47 +1,31 @@
 package promtailconfig
 
 import (
-	"fmt"
+	"context"
 	"sort"
-	"strings"
-	"time"
 
-	"github.com/giantswarm/microerror"
-	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // Key allows to identify a promtail config for a specific ContainerName running
 // in a pod selectable by Labels in Namespace.
-// Key's values must be rendered as comments into the final config map
-// so it's possible to load the map from a config file and recreate existing keys.
+// Keys are stored with their snippets in the StateKeyName key of the final
+// config map, so existing keys can be recreated without parsing promtail's
+// config.
 // To make the Keys easily comparable and possible to use as map keys,
 // Labels are not stored as "map[string]string", but just string of format
-// "k1=v1,k2=v2,..."
+// "k1=v1,k2=v2,...". Labels must be a valid label selector, as it is used
+// to generate the filter restricting the promtail job to the selected pods.
+// Workload names the controller owning the selected pods in the format
+// "Kind/name", e.g. "Deployment/apiserver". It is empty for Keys not
+// derived from pods.
 type Key struct {
 	Namespace     string
+	Workload      string
 	Labels        string
 	ContainerName string
 }
 
-func NewKey(pod *v1.Pod, containerName string) *Key {
-	var labels strings.Builder
-	labelKeys := []string{}
-	for k := range pod.ObjectMeta.Labels {
-		labelKeys = append(labelKeys, k)
-	}
-	sort.Strings(labelKeys)
-	for _, k := range labelKeys {
-		v := pod.ObjectMeta.Labels[k]
-		labels.WriteString(fmt.Sprintf("%v=%v,", k, v))
-	}
-
-	return &Key{
-		Namespace:     pod.Namespace,
-		Labels:        labels.String(),
-		ContainerName: containerName,
-	}
-}
-
 func SortKeys(keys []Key) {
 	sort.Slice(keys, func(i, j int) bool {
 		if keys[i].Namespace < keys[j].Namespace {
@@ -50,6 +34,12 @@ func SortKeys(keys []Key) {
 			return false
 		}
 		// here it means Namespace values are equal, move to the next one
+		if keys[i].Workload < keys[j].Workload {
+			return true
+		} else if keys[i].Workload > keys[j].Workload {
+			return false
+		}
+		// here it means Workload values are equal, move to the next one
 		if keys[i].Labels < keys[j].Labels {
 			return true
 		} else if keys[i].Labels > keys[j].Labels {
@@ -62,70 +52,31 @@ func SortKeys(keys []Key) {
 
 // Handler is an interface that delivers operations required to sync between
 // events created by pods with related configmap and the actual promtail's
-// configmap.
+// configmap. Every snippet is contributed for a Key by a source, which is the
+// UID of the pod or PromtailConfig it comes from. AddConfig returns an error
+// matched by IsInvalidSnippet when the snippet is rejected and an error
+// matched by IsSnippetConflict when sources of the same Key contribute
+// different snippets.
 type Handler interface {
-	AddConfig(key Key, yamlContent string)
-	DelConfig(key Key)
-}
-
-// PeriodicHandler is an implementation of handler, that loads promtail's configmap
-// at the beginning, parses it and stores with appropriate Keys in the snippet field.
-// Then it allows for AddConfig/DelConfig calls to be made.
-// PeriodicHandler has a configurable timer, that periodically renders all the
-// data in snippets and produces a new value for the promtail's configmap.
-type PeriodicHandler struct {
-	snippets     map[Key]string
-	initialDelay time.Duration
-	period       time.Duration
-	promMap      *PromtailConfigMap
-	updateTimer  *time.Timer
-}
-
-func NewPeriodicHandler(initialDelay time.Duration, period time.Duration, promMap *PromtailConfigMap) (Handler, error) {
-	if initialDelay <= 0 {
-		return nil, microerror.New("initialDelay must be > 0")
-	}
-	if period <= 0 {
-		return nil, microerror.New("period must be > 0")
-	}
-	if promMap == nil {
-		return nil, microerror.New("promMap can't be nil")
-	}
-
-	ph := &PeriodicHandler{
-		snippets:     make(map[Key]string),
-		initialDelay: initialDelay,
-		period:       period,
-		promMap:      promMap,
-	}
-	if err := ph.init(); err != nil {
-		return nil, err
-	}
-	return ph, nil
-}
-
-func (p *PeriodicHandler) AddConfig(key Key, yamlContent string) {
-	p.snippets[key] = yamlContent
-}
-
-func (p *PeriodicHandler) DelConfig(key Key) {
-	if _, found := p.snippets[key]; found {
-		delete(p.snippets, key)
-	}
-}
-
-func (p *PeriodicHandler) init() error {
-	time.AfterFunc(p.initialDelay, func() {
-		p.updateTimer = time.NewTimer(p.period)
-		p.promMap.Update(p.snippets)
-		go p.handleUpdateTimer()
-	})
-	return nil
-}
-
-func (p *PeriodicHandler) handleUpdateTimer() {
-	for {
-		<-p.updateTimer.C
-		p.promMap.Update(p.snippets)
-	}
+	AddConfig(key Key, source types.UID, yamlContent string) error
+	DelConfig(key Key, source types.UID)
+	// DelSource removes all the snippets contributed by source, whatever
+	// their Keys are.
+	DelSource(source types.UID)
+	// RetainConfigs removes the snippets contributed by source for Keys
+	// other than keys.
+	RetainConfigs(source types.UID, keys []Key)
+	// Registrations returns the snippets currently registered.
+	Registrations() []Registration
+	// Synced tells the handler that the snippets of all existing sources
+	// are registered. Nothing is written to promtail's configmap before the
+	// first call, so jobs of existing sources aren't dropped at startup.
+	// Every call schedules a write, even when no snippet changed, so jobs
+	// not registered anymore are removed from the configmap.
+	Synced()
+	// Refresh schedules a write of promtail's configmap, even when no
+	// snippet changed, e.g. because its base config changed.
+	Refresh()
+	// Boot runs the handler's sync loop until ctx is canceled.
+	Boot(ctx context.Context)
 }
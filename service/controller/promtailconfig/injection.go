@@ -0,0 +1,36 @@
+package promtailconfig
+
+const (
+	mandatoryStageComment = "# loki-operator.mandatory-stage"
+	tenantStageComment    = "# loki-operator.tenant-stage"
+)
+
+// injection describes the stages the operator put into every job of a Key.
+type injection struct {
+	// prepended and appended are the numbers of mandatory stages at the
+	// start and at the end of the jobs.
+	prepended int
+	appended  int
+	// tenant tells whether the jobs end with a tenant stage.
+	tenant bool
+}
+
+// comments returns the comments marking the injected stages of a job with n
+// pipeline stages, by stage index. Stages of the snippet are not marked.
+func (i injection) comments(n int) map[int]string {
+	comments := map[int]string{}
+
+	end := n
+	if i.tenant {
+		end--
+		comments[end] = tenantStageComment
+	}
+	for s := 0; s < i.prepended; s++ {
+		comments[s] = mandatoryStageComment
+	}
+	for s := end - i.appended; s < end; s++ {
+		comments[s] = mandatoryStageComment
+	}
+
+	return comments
+}
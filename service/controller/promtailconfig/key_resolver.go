@@ -0,0 +1,322 @@
+package promtailconfig
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxOwnerDepth limits the walk up the ownerReferences of a pod. The
+	// deepest supported chain is Pod, Job, CronJob.
+	maxOwnerDepth = 3
+
+	// DefaultOwnerCacheSize is the default number of owners kept by
+	// CachedOwnerGetter.
+	DefaultOwnerCacheSize = 1000
+	// DefaultOwnerCacheTTL is the default time CachedOwnerGetter keeps an
+	// owner.
+	DefaultOwnerCacheTTL = 10 * time.Minute
+)
+
+// DefaultIgnoredLabels are the labels set by Kubernetes controllers, which
+// change with every rollout or every pod, and so must not become part of a
+// Key.
+var DefaultIgnoredLabels = []string{
+	"controller-revision-hash",
+	"controller-uid",
+	"job-name",
+	"pod-template-generation",
+	"pod-template-hash",
+	"statefulset.kubernetes.io/pod-name",
+}
+
+// OwnerGetter looks up the controllers owning pods.
+type OwnerGetter interface {
+	// GetOwner returns the object referenced by ref in namespace and the
+	// selector of the pods it controls, if it has one.
+	GetOwner(namespace string, ref metav1.OwnerReference) (metav1.Object, *metav1.LabelSelector, error)
+}
+
+type KeyResolverConfig struct {
+	OwnerGetter OwnerGetter
+
+	// IgnoredLabels are the labels never put into a Key. Defaults to
+	// DefaultIgnoredLabels.
+	IgnoredLabels []string
+}
+
+// KeyResolver builds Keys that are stable over the lifetime of the workload
+// owning a pod. All pods of a workload share the same Key, even across
+// rolling updates.
+type KeyResolver struct {
+	ownerGetter   OwnerGetter
+	ignoredLabels map[string]bool
+}
+
+func NewKeyResolver(config KeyResolverConfig) (*KeyResolver, error) {
+	if config.OwnerGetter == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.OwnerGetter must not be empty", config)
+	}
+	if config.IgnoredLabels == nil {
+		config.IgnoredLabels = DefaultIgnoredLabels
+	}
+
+	ignoredLabels := make(map[string]bool, len(config.IgnoredLabels))
+	for _, l := range config.IgnoredLabels {
+		ignoredLabels[l] = true
+	}
+
+	k := &KeyResolver{
+		ownerGetter:   config.OwnerGetter,
+		ignoredLabels: ignoredLabels,
+	}
+
+	return k, nil
+}
+
+// NewKey returns the Key for containerName of pod. The Key is derived from
+// the top-most controller found by walking the pod's ownerReferences, e.g.
+// from ReplicaSet up to Deployment. Its Labels are taken from the pod
+// selector of that controller or, if there is no usable selector, from the
+// labels of the pod. Ignored labels are left out in both cases.
+func (k *KeyResolver) NewKey(pod *v1.Pod, containerName string) (*Key, error) {
+	workload := fmt.Sprintf("Pod/%s", pod.Name)
+	var selector *metav1.LabelSelector
+
+	var owned metav1.Object = pod
+	for i := 0; i < maxOwnerDepth; i++ {
+		ref := metav1.GetControllerOf(owned)
+		if ref == nil {
+			break
+		}
+
+		owner, ownerSelector, err := k.ownerGetter.GetOwner(pod.Namespace, *ref)
+		if IsUnsupportedOwner(err) {
+			break
+		} else if errors.IsNotFound(microerror.Cause(err)) {
+			// The owner is being deleted, the pod is going to be
+			// deleted as well.
+			break
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		workload = fmt.Sprintf("%s/%s", ref.Kind, ref.Name)
+		selector = ownerSelector
+		owned = owner
+	}
+
+	labels, err := k.selectorString(selector)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if labels == "" {
+		labels, err = k.selectorString(&metav1.LabelSelector{MatchLabels: pod.Labels})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	key := &Key{
+		Namespace:     pod.Namespace,
+		Workload:      workload,
+		Labels:        labels,
+		ContainerName: containerName,
+	}
+
+	return key, nil
+}
+
+// selectorString returns selector without ignored labels in the format used
+// by Key.Labels.
+func (k *KeyResolver) selectorString(selector *metav1.LabelSelector) (string, error) {
+	if selector == nil {
+		return "", nil
+	}
+
+	stable := &metav1.LabelSelector{
+		MatchLabels: map[string]string{},
+	}
+	for l, v := range selector.MatchLabels {
+		if !k.ignoredLabels[l] {
+			stable.MatchLabels[l] = v
+		}
+	}
+	for _, e := range selector.MatchExpressions {
+		if !k.ignoredLabels[e.Key] {
+			stable.MatchExpressions = append(stable.MatchExpressions, e)
+		}
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(stable)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return s.String(), nil
+}
+
+// K8sOwnerGetter is an OwnerGetter looking up the controllers in the
+// Kubernetes API.
+type K8sOwnerGetter struct {
+	k8sClient kubernetes.Interface
+}
+
+func NewK8sOwnerGetter(k8sClient kubernetes.Interface) (*K8sOwnerGetter, error) {
+	if k8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "k8sClient must not be empty")
+	}
+
+	g := &K8sOwnerGetter{
+		k8sClient: k8sClient,
+	}
+
+	return g, nil
+}
+
+func (g *K8sOwnerGetter) GetOwner(namespace string, ref metav1.OwnerReference) (metav1.Object, *metav1.LabelSelector, error) {
+	switch ref.Kind {
+	case "ReplicaSet":
+		o, err := g.k8sClient.AppsV1().ReplicaSets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		return o, o.Spec.Selector, nil
+	case "Deployment":
+		o, err := g.k8sClient.AppsV1().Deployments(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		return o, o.Spec.Selector, nil
+	case "StatefulSet":
+		o, err := g.k8sClient.AppsV1().StatefulSets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		return o, o.Spec.Selector, nil
+	case "DaemonSet":
+		o, err := g.k8sClient.AppsV1().DaemonSets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		return o, o.Spec.Selector, nil
+	case "Job":
+		o, err := g.k8sClient.BatchV1().Jobs(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		return o, o.Spec.Selector, nil
+	case "CronJob":
+		o, err := g.k8sClient.BatchV1beta1().CronJobs(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		return o, &metav1.LabelSelector{MatchLabels: o.Spec.JobTemplate.Spec.Template.Labels}, nil
+	}
+
+	return nil, nil, microerror.Maskf(unsupportedOwnerError, "owner kind %#q", ref.Kind)
+}
+
+type CachedOwnerGetterConfig struct {
+	OwnerGetter OwnerGetter
+
+	// Size is the maximum number of owners kept. Defaults to
+	// DefaultOwnerCacheSize.
+	Size int
+	// TTL is the time an owner is kept. Defaults to DefaultOwnerCacheTTL.
+	TTL time.Duration
+}
+
+// CachedOwnerGetter is an OwnerGetter keeping the owners looked up by another
+// OwnerGetter for a while. All pods of a workload share their owners, so
+// resolving the Keys of their snippets hits the API only once. The selectors
+// of controllers are immutable and owners are identified by their UID, so
+// cached owners only get stale when the labels of a CronJob's pod template
+// change. Failed lookups aren't cached.
+type CachedOwnerGetter struct {
+	cache       *cache.LRUExpireCache
+	ownerGetter OwnerGetter
+	ttl         time.Duration
+}
+
+type ownerCacheKey struct {
+	Namespace string
+	Kind      string
+	Name      string
+	UID       types.UID
+}
+
+type cachedOwner struct {
+	owner    metav1.Object
+	selector *metav1.LabelSelector
+}
+
+func NewCachedOwnerGetter(config CachedOwnerGetterConfig) (*CachedOwnerGetter, error) {
+	return newCachedOwnerGetter(config, nil)
+}
+
+// newCachedOwnerGetter allows tests to control the expiry of owners with
+// clock. The real clock is used when clock is nil.
+func newCachedOwnerGetter(config CachedOwnerGetterConfig, clock cache.Clock) (*CachedOwnerGetter, error) {
+	if config.OwnerGetter == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.OwnerGetter must not be empty", config)
+	}
+	if config.Size < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Size must not be negative", config)
+	}
+	if config.TTL < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.TTL must not be negative", config)
+	}
+	if config.Size == 0 {
+		config.Size = DefaultOwnerCacheSize
+	}
+	if config.TTL == 0 {
+		config.TTL = DefaultOwnerCacheTTL
+	}
+
+	var c *cache.LRUExpireCache
+	if clock == nil {
+		c = cache.NewLRUExpireCache(config.Size)
+	} else {
+		c = cache.NewLRUExpireCacheWithClock(config.Size, clock)
+	}
+
+	g := &CachedOwnerGetter{
+		cache:       c,
+		ownerGetter: config.OwnerGetter,
+		ttl:         config.TTL,
+	}
+
+	return g, nil
+}
+
+func (g *CachedOwnerGetter) GetOwner(namespace string, ref metav1.OwnerReference) (metav1.Object, *metav1.LabelSelector, error) {
+	key := ownerCacheKey{
+		Namespace: namespace,
+		Kind:      ref.Kind,
+		Name:      ref.Name,
+		UID:       ref.UID,
+	}
+
+	if v, found := g.cache.Get(key); found {
+		o := v.(cachedOwner)
+		return o.owner, o.selector, nil
+	}
+
+	owner, selector, err := g.ownerGetter.GetOwner(namespace, ref)
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	g.cache.Add(key, cachedOwner{owner: owner, selector: selector}, g.ttl)
+
+	return owner, selector, nil
+}
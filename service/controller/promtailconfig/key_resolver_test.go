@@ -0,0 +1,479 @@
+package promtailconfig
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var testError = &microerror.Error{
+	Kind: "testError",
+}
+
+// fakeOwnerGetter serves the owners it holds by "Kind/name" and counts the
+// lookups.
+type fakeOwnerGetter struct {
+	owners map[string]fakeOwner
+	calls  int
+}
+
+type fakeOwner struct {
+	object   metav1.Object
+	selector *metav1.LabelSelector
+	err      error
+}
+
+func (g *fakeOwnerGetter) GetOwner(namespace string, ref metav1.OwnerReference) (metav1.Object, *metav1.LabelSelector, error) {
+	g.calls++
+
+	o, found := g.owners[fmt.Sprintf("%s/%s", ref.Kind, ref.Name)]
+	if !found {
+		return nil, nil, microerror.Maskf(unsupportedOwnerError, "owner kind %#q", ref.Kind)
+	}
+	if o.err != nil {
+		return nil, nil, microerror.Mask(o.err)
+	}
+
+	return o.object, o.selector, nil
+}
+
+func controllerRef(kind, name string) []metav1.OwnerReference {
+	isController := true
+	return []metav1.OwnerReference{
+		{
+			Kind:       kind,
+			Name:       name,
+			UID:        types.UID(name + "-uid"),
+			Controller: &isController,
+		},
+	}
+}
+
+func Test_KeyResolver_NewKey(t *testing.T) {
+	testCases := []struct {
+		name          string
+		ignoredLabels []string
+		owners        map[string]fakeOwner
+		pod           *v1.Pod
+		expected      *Key
+		errorMatcher  func(error) bool
+	}{
+		{
+			name: "case 0: pod without owner",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "web-0",
+					Labels: map[string]string{
+						"app":                                "web",
+						"statefulset.kubernetes.io/pod-name": "web-0",
+					},
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "Pod/web-0",
+				Labels:        "app=web",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name: "case 1: pod of a deployment",
+			owners: map[string]fakeOwner{
+				"ReplicaSet/web-5d4f8": {
+					object: &metav1.ObjectMeta{
+						Name:            "web-5d4f8",
+						OwnerReferences: controllerRef("Deployment", "web"),
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "web", "pod-template-hash": "5d4f8"},
+					},
+				},
+				"Deployment/web": {
+					object: &metav1.ObjectMeta{
+						Name: "web",
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "web"},
+					},
+				},
+			},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "web-5d4f8-x2x7k",
+					Labels:          map[string]string{"app": "web", "pod-template-hash": "5d4f8", "version": "1"},
+					OwnerReferences: controllerRef("ReplicaSet", "web-5d4f8"),
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "Deployment/web",
+				Labels:        "app=web",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name: "case 2: pod of a cron job",
+			owners: map[string]fakeOwner{
+				"Job/backup-1600000000": {
+					object: &metav1.ObjectMeta{
+						Name:            "backup-1600000000",
+						OwnerReferences: controllerRef("CronJob", "backup"),
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"controller-uid": "1234"},
+					},
+				},
+				"CronJob/backup": {
+					object: &metav1.ObjectMeta{
+						Name: "backup",
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "backup"},
+					},
+				},
+			},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "backup-1600000000-abcde",
+					Labels:          map[string]string{"app": "backup", "controller-uid": "1234", "job-name": "backup-1600000000"},
+					OwnerReferences: controllerRef("Job", "backup-1600000000"),
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "CronJob/backup",
+				Labels:        "app=backup",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name: "case 3: pod of a job without cron job",
+			owners: map[string]fakeOwner{
+				"Job/migrate": {
+					object: &metav1.ObjectMeta{
+						Name: "migrate",
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"controller-uid": "1234"},
+					},
+				},
+			},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "migrate-abcde",
+					Labels:          map[string]string{"app": "migrate", "controller-uid": "1234", "job-name": "migrate"},
+					OwnerReferences: controllerRef("Job", "migrate"),
+				},
+			},
+			// The selector of the job only holds ignored labels, so the
+			// labels of the pod are used.
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "Job/migrate",
+				Labels:        "app=migrate",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name: "case 4: pod of an unsupported owner",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "web-abcde",
+					Labels:          map[string]string{"app": "web"},
+					OwnerReferences: controllerRef("Rollout", "web"),
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "Pod/web-abcde",
+				Labels:        "app=web",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name: "case 5: owner being deleted",
+			owners: map[string]fakeOwner{
+				"ReplicaSet/web-5d4f8": {
+					object: &metav1.ObjectMeta{
+						Name:            "web-5d4f8",
+						OwnerReferences: controllerRef("Deployment", "web"),
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "web", "pod-template-hash": "5d4f8"},
+					},
+				},
+				"Deployment/web": {
+					err: errors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web"),
+				},
+			},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "web-5d4f8-x2x7k",
+					Labels:          map[string]string{"app": "web", "pod-template-hash": "5d4f8"},
+					OwnerReferences: controllerRef("ReplicaSet", "web-5d4f8"),
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "ReplicaSet/web-5d4f8",
+				Labels:        "app=web",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name: "case 6: owner lookup failing",
+			owners: map[string]fakeOwner{
+				"ReplicaSet/web-5d4f8": {
+					err: testError,
+				},
+			},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "web-5d4f8-x2x7k",
+					Labels:          map[string]string{"app": "web"},
+					OwnerReferences: controllerRef("ReplicaSet", "web-5d4f8"),
+				},
+			},
+			errorMatcher: func(err error) bool {
+				return microerror.Cause(err) == testError
+			},
+		},
+		{
+			name: "case 7: ignored match expressions left out",
+			owners: map[string]fakeOwner{
+				"StatefulSet/db": {
+					object: &metav1.ObjectMeta{
+						Name: "db",
+					},
+					selector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "db"},
+						MatchExpressions: []metav1.LabelSelectorRequirement{
+							{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"primary", "replica"}},
+							{Key: "controller-revision-hash", Operator: metav1.LabelSelectorOpExists},
+						},
+					},
+				},
+			},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "default",
+					Name:            "db-0",
+					Labels:          map[string]string{"app": "db", "tier": "primary"},
+					OwnerReferences: controllerRef("StatefulSet", "db"),
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "StatefulSet/db",
+				Labels:        "app=db,tier in (primary,replica)",
+				ContainerName: "nginx",
+			},
+		},
+		{
+			name:          "case 8: configured ignored labels",
+			ignoredLabels: []string{"version"},
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "web",
+					Labels:    map[string]string{"app": "web", "pod-template-hash": "5d4f8", "version": "1"},
+				},
+			},
+			expected: &Key{
+				Namespace:     "default",
+				Workload:      "Pod/web",
+				Labels:        "app=web,pod-template-hash=5d4f8",
+				ContainerName: "nginx",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := KeyResolverConfig{
+				OwnerGetter: &fakeOwnerGetter{owners: tc.owners},
+
+				IgnoredLabels: tc.ignoredLabels,
+			}
+			keyResolver, err := NewKeyResolver(c)
+			if err != nil {
+				t.Fatalf("expected no error creating the key resolver, got %#q", err)
+			}
+
+			key, err := keyResolver.NewKey(tc.pod, "nginx")
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+
+			if !reflect.DeepEqual(key, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, key)
+			}
+		})
+	}
+}
+
+// fakeClock is a cache.Clock whose time only moves when told so.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func Test_CachedOwnerGetter(t *testing.T) {
+	ownerGetter := &fakeOwnerGetter{
+		owners: map[string]fakeOwner{
+			"Deployment/web": {
+				object: &metav1.ObjectMeta{
+					Name: "web",
+				},
+				selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "web"},
+				},
+			},
+			"Deployment/broken": {
+				err: testError,
+			},
+		},
+	}
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+
+	c := CachedOwnerGetterConfig{
+		OwnerGetter: ownerGetter,
+		TTL:         time.Minute,
+	}
+	g, err := newCachedOwnerGetter(c, clock)
+	if err != nil {
+		t.Fatalf("expected no error creating the owner getter, got %#q", err)
+	}
+
+	web := controllerRef("Deployment", "web")[0]
+	webRecreated := web
+	webRecreated.UID = "web-recreated-uid"
+	broken := controllerRef("Deployment", "broken")[0]
+
+	testCases := []struct {
+		name          string
+		namespace     string
+		ref           metav1.OwnerReference
+		advance       time.Duration
+		expectedCalls int
+		errorMatcher  func(error) bool
+	}{
+		{
+			name:          "case 0: first lookup delegated",
+			namespace:     "default",
+			ref:           web,
+			expectedCalls: 1,
+		},
+		{
+			name:          "case 1: second lookup cached",
+			namespace:     "default",
+			ref:           web,
+			expectedCalls: 1,
+		},
+		{
+			name:          "case 2: same owner in another namespace delegated",
+			namespace:     "other",
+			ref:           web,
+			expectedCalls: 2,
+		},
+		{
+			name:          "case 3: recreated owner delegated",
+			namespace:     "default",
+			ref:           webRecreated,
+			expectedCalls: 3,
+		},
+		{
+			name:          "case 4: lookup before the ttl cached",
+			namespace:     "default",
+			ref:           web,
+			advance:       59 * time.Second,
+			expectedCalls: 3,
+		},
+		{
+			name:          "case 5: lookup after the ttl delegated",
+			namespace:     "default",
+			ref:           web,
+			advance:       2 * time.Second,
+			expectedCalls: 4,
+		},
+		{
+			name:          "case 6: failed lookup",
+			namespace:     "default",
+			ref:           broken,
+			expectedCalls: 5,
+			errorMatcher: func(err error) bool {
+				return microerror.Cause(err) == testError
+			},
+		},
+		{
+			name:          "case 7: failed lookup not cached",
+			namespace:     "default",
+			ref:           broken,
+			expectedCalls: 6,
+			errorMatcher: func(err error) bool {
+				return microerror.Cause(err) == testError
+			},
+		},
+	}
+
+	// The cases build on each other, so they run in order on the same
+	// owner getter.
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock.now = clock.now.Add(tc.advance)
+
+			owner, selector, err := g.GetOwner(tc.namespace, tc.ref)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+			if ownerGetter.calls != tc.expectedCalls {
+				t.Fatalf("expected %d lookups, got %d", tc.expectedCalls, ownerGetter.calls)
+			}
+			if tc.errorMatcher != nil {
+				return
+			}
+
+			if owner.GetName() != tc.ref.Name {
+				t.Fatalf("expected owner %#q, got %#q", tc.ref.Name, owner.GetName())
+			}
+			expectedSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+			if !reflect.DeepEqual(selector, expectedSelector) {
+				t.Fatalf("expected selector %#v, got %#v", expectedSelector, selector)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package promtailconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+// DefaultDeniedLabels are label names identifying single requests, users or
+// sessions, which have far too many values to be labels in Loki.
+var DefaultDeniedLabels = []string{
+	"request_id",
+	"session_id",
+	"span_id",
+	"trace_id",
+	"user_id",
+}
+
+// DefaultMaxLabels is the default maximum number of labels the labels stages
+// of a job may promote.
+const DefaultMaxLabels = 10
+
+// PolicyConfig configures a Policy.
+type PolicyConfig struct {
+	// PrivilegedNamespaces lists the namespaces whose snippets may use any
+	// scrape configs.
+	PrivilegedNamespaces []string
+
+	// AllowedLabels lists the only label names labels stages may promote.
+	// All label names are allowed when empty.
+	AllowedLabels []string
+	// DeniedLabels lists label names labels stages must not promote, like
+	// request IDs, whose many values would overload Loki.
+	DeniedLabels []string
+	// MaxLabels is the maximum number of labels the labels stages of a job
+	// may promote. The number is not limited when it is zero.
+	MaxLabels int
+}
+
+// Policy keeps snippets from reading logs their namespace has no business
+// with and from overloading Loki with labels of high cardinality.
+//
+// Pipeline snippets can't read other logs, as their jobs are generated by the
+// operator. Scrape configs of snippets coming from namespaces not privileged
+// may only discover pods, the operator restricts them to the pods of their
+// namespace and sets the files they tail.
+//
+// The label names promoted by the labels stages of every job, including the
+// ones nested in match stages, are checked against the allowed and denied
+// label names and counted.
+type Policy struct {
+	privileged map[string]bool
+
+	allowedLabels map[string]bool
+	deniedLabels  map[string]bool
+	maxLabels     int
+}
+
+// NewPolicy returns a Policy enforcing config.
+func NewPolicy(config PolicyConfig) (*Policy, error) {
+	if config.MaxLabels < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.MaxLabels must not be negative", config)
+	}
+
+	p := &Policy{
+		privileged: set(config.PrivilegedNamespaces),
+
+		allowedLabels: set(config.AllowedLabels),
+		deniedLabels:  set(config.DeniedLabels),
+		maxLabels:     config.MaxLabels,
+	}
+
+	return p, nil
+}
+
+// Check returns an error matched by IsInvalidSnippet, telling all the
+// violations, when snippet is not allowed for key.
+func (p *Policy) Check(key Key, snippet *Snippet) error {
+	var problems []string
+	addf := func(job string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("job %#q: %s", job, fmt.Sprintf(format, args...)))
+	}
+
+	if snippet.ScrapeConfigs == nil {
+		for _, problem := range p.checkLabels(snippet.PipelineStages) {
+			problems = append(problems, fmt.Sprintf("pipeline_stages: %s", problem))
+		}
+	}
+
+	for _, s := range snippet.ScrapeConfigs {
+		for _, problem := range p.checkLabels(s.PipelineStages) {
+			addf(s.JobName, "%s", problem)
+		}
+		if p.privileged[key.Namespace] {
+			continue
+		}
+
+		if len(s.StaticConfigs) > 0 {
+			addf(s.JobName, "static_configs are only allowed in privileged namespaces")
+		}
+		if s.Journal != nil {
+			addf(s.JobName, "journal is only allowed in privileged namespaces")
+		}
+		for _, sd := range s.KubernetesSDConfigs {
+			if sd.Role != "pod" {
+				addf(s.JobName, "kubernetes_sd_configs role %#q is only allowed in privileged namespaces", sd.Role)
+			}
+			if sd.Namespaces == nil {
+				continue
+			}
+			for _, n := range sd.Namespaces.Names {
+				if n != key.Namespace {
+					addf(s.JobName, "kubernetes_sd_configs must not select namespace %#q", n)
+				}
+			}
+		}
+		for i, r := range s.RelabelConfigs {
+			switch r.Action {
+			case "", "replace", "keep", "drop", "hashmod":
+			default:
+				// labelmap, labeldrop and labelkeep can rewrite the
+				// labels the operator derives the tailed files from.
+				addf(s.JobName, "relabel config %d: action %#q is only allowed in privileged namespaces", i+1, r.Action)
+			}
+			if strings.HasPrefix(r.TargetLabel, "__") {
+				addf(s.JobName, "relabel config %d: target_label %#q is only allowed in privileged namespaces", i+1, r.TargetLabel)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return microerror.Maskf(invalidSnippetError, "%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// checkLabels returns the problems of the labels promoted by the labels
+// stages of a job.
+func (p *Policy) checkLabels(stages []promtail.PipelineStage) []string {
+	var problems []string
+
+	names := promtail.LabelNames(stages)
+	for _, name := range names {
+		if p.deniedLabels[name] {
+			problems = append(problems, fmt.Sprintf("label %#q must not be promoted, keep it in the log line instead", name))
+		} else if len(p.allowedLabels) > 0 && !p.allowedLabels[name] {
+			problems = append(problems, fmt.Sprintf("label %#q is not among the allowed labels", name))
+		}
+	}
+	if p.maxLabels > 0 && len(names) > p.maxLabels {
+		problems = append(problems, fmt.Sprintf("labels stages promote %d labels, at most %d are allowed", len(names), p.maxLabels))
+	}
+
+	return problems
+}
+
+// Restrict returns the jobs of key restricted to the pods of its namespace.
+// They keep the targets of the namespace only, before any relabeling of the
+// snippet, and tail the log files of the target containers, after it. Jobs of
+// privileged namespaces are returned as they are.
+func (p *Policy) Restrict(key Key, jobs []promtail.ScrapeConfig) []promtail.ScrapeConfig {
+	if p.privileged[key.Namespace] {
+		return jobs
+	}
+
+	var restricted []promtail.ScrapeConfig
+	for _, job := range jobs {
+		var sdConfigs []promtail.KubernetesSDConfig
+		for _, sd := range job.KubernetesSDConfigs {
+			sd.Namespaces = &promtail.KubernetesNamespaces{
+				Names: []string{key.Namespace},
+			}
+			sdConfigs = append(sdConfigs, sd)
+		}
+		job.KubernetesSDConfigs = sdConfigs
+
+		var relabelConfigs []promtail.RelabelConfig
+		relabelConfigs = append(relabelConfigs, newNamespaceRelabelConfig(key.Namespace))
+		relabelConfigs = append(relabelConfigs, job.RelabelConfigs...)
+		relabelConfigs = append(relabelConfigs, newPathRelabelConfig())
+		job.RelabelConfigs = relabelConfigs
+
+		restricted = append(restricted, job)
+	}
+
+	return restricted
+}
+
+func set(values []string) map[string]bool {
+	m := map[string]bool{}
+	for _, v := range values {
+		m[v] = true
+	}
+
+	return m
+}
@@ -0,0 +1,603 @@
+package promtailconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+func Test_Policy_Check(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  PolicyConfig
+		key     Key
+		snippet string
+		// errorMatches are parts of the expected error message. No error is
+		// expected when it is empty.
+		errorMatches []string
+	}{
+		{
+			name: "case 0: labels neither denied nor limited",
+			config: PolicyConfig{
+				DeniedLabels: DefaultDeniedLabels,
+			},
+			snippet: `
+pipeline_stages:
+- regex:
+    expression: '^(?P<level>\w+) (?P<msg>.*)$'
+- labels:
+    level:
+`,
+		},
+		{
+			name: "case 1: denied label",
+			config: PolicyConfig{
+				DeniedLabels: DefaultDeniedLabels,
+			},
+			snippet: `
+pipeline_stages:
+- json:
+    expressions:
+      level: level
+      trace_id: trace.id
+- labels:
+    level:
+    trace_id:
+`,
+			errorMatches: []string{"pipeline_stages: label `trace_id` must not be promoted"},
+		},
+		{
+			name: "case 2: denied label nested in a match stage",
+			config: PolicyConfig{
+				DeniedLabels: DefaultDeniedLabels,
+			},
+			snippet: `
+pipeline_stages:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - json:
+        expressions:
+          user_id: user.id
+    - labels:
+        user_id:
+`,
+			errorMatches: []string{"label `user_id` must not be promoted"},
+		},
+		{
+			name: "case 3: denied label nested in two match stages",
+			config: PolicyConfig{
+				DeniedLabels: DefaultDeniedLabels,
+			},
+			snippet: `
+pipeline_stages:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - match:
+        selector: '{app="web", tier="frontend"}'
+        stages:
+        - labels:
+            session_id:
+`,
+			errorMatches: []string{"label `session_id` must not be promoted"},
+		},
+		{
+			name: "case 4: allowed labels only",
+			config: PolicyConfig{
+				AllowedLabels: []string{"level", "component"},
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+    component:
+`,
+		},
+		{
+			name: "case 5: label not among the allowed ones",
+			config: PolicyConfig{
+				AllowedLabels: []string{"level", "component"},
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+    method:
+`,
+			errorMatches: []string{"label `method` is not among the allowed labels"},
+		},
+		{
+			name: "case 6: nested label not among the allowed ones",
+			config: PolicyConfig{
+				AllowedLabels: []string{"level"},
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        path:
+`,
+			errorMatches: []string{"label `path` is not among the allowed labels"},
+		},
+		{
+			name: "case 7: denied label also allowed",
+			config: PolicyConfig{
+				AllowedLabels: []string{"level", "request_id"},
+				DeniedLabels:  []string{"request_id"},
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    request_id:
+`,
+			errorMatches: []string{"label `request_id` must not be promoted"},
+		},
+		{
+			name: "case 8: labels at the maximum",
+			config: PolicyConfig{
+				MaxLabels: 2,
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        component:
+`,
+		},
+		{
+			name: "case 9: labels above the maximum, nested ones counted",
+			config: PolicyConfig{
+				MaxLabels: 2,
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        component:
+        method:
+`,
+			errorMatches: []string{"labels stages promote 3 labels, at most 2 are allowed"},
+		},
+		{
+			name: "case 10: label promoted twice counted once",
+			config: PolicyConfig{
+				MaxLabels: 1,
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        level:
+`,
+		},
+		{
+			name:   "case 11: labels not limited without maximum",
+			config: PolicyConfig{},
+			snippet: `
+pipeline_stages:
+- labels:
+    a:
+    b:
+    c:
+    d:
+    e:
+    f:
+    g:
+    h:
+    i:
+    j:
+    k:
+`,
+		},
+		{
+			name: "case 12: all violations reported",
+			config: PolicyConfig{
+				AllowedLabels: []string{"level"},
+				DeniedLabels:  DefaultDeniedLabels,
+				MaxLabels:     1,
+			},
+			snippet: `
+pipeline_stages:
+- labels:
+    level:
+    method:
+    span_id:
+`,
+			errorMatches: []string{
+				"label `method` is not among the allowed labels",
+				"label `span_id` must not be promoted",
+				"labels stages promote 3 labels, at most 1 are allowed",
+			},
+		},
+		{
+			name: "case 13: scrape config discovering pods of its namespace",
+			config: PolicyConfig{
+				DeniedLabels: DefaultDeniedLabels,
+			},
+			key: Key{Namespace: "team"},
+			snippet: `
+- job_name: team/app
+  kubernetes_sd_configs:
+  - role: pod
+    namespaces:
+      names:
+      - team
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_pod_label_app]
+    action: keep
+    regex: app
+  pipeline_stages:
+  - labels:
+      level:
+`,
+		},
+		{
+			name: "case 14: denied label in a scrape config",
+			config: PolicyConfig{
+				DeniedLabels: DefaultDeniedLabels,
+			},
+			key: Key{Namespace: "team"},
+			snippet: `
+- job_name: team/app
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - match:
+      selector: '{app="app"}'
+      stages:
+      - labels:
+          request_id:
+`,
+			errorMatches: []string{"job `team/app`: label `request_id` must not be promoted"},
+		},
+		{
+			name:   "case 15: scrape config of a namespace not privileged",
+			config: PolicyConfig{},
+			key:    Key{Namespace: "team"},
+			snippet: `
+- job_name: team/nodes
+  kubernetes_sd_configs:
+  - role: node
+  - role: pod
+    namespaces:
+      names:
+      - kube-system
+  relabel_configs:
+  - action: labelmap
+    regex: __meta_kubernetes_pod_label_(.+)
+  - source_labels: [__meta_kubernetes_pod_uid]
+    target_label: __path__
+`,
+			errorMatches: []string{
+				"job `team/nodes`: kubernetes_sd_configs role `node` is only allowed in privileged namespaces",
+				"kubernetes_sd_configs must not select namespace `kube-system`",
+				"relabel config 1: action `labelmap` is only allowed in privileged namespaces",
+				"relabel config 2: target_label `__path__` is only allowed in privileged namespaces",
+			},
+		},
+		{
+			name: "case 16: scrape config of a privileged namespace",
+			config: PolicyConfig{
+				PrivilegedNamespaces: []string{"kube-system"},
+			},
+			key: Key{Namespace: "kube-system"},
+			snippet: `
+- job_name: kube-system/nodes
+  kubernetes_sd_configs:
+  - role: node
+  - role: pod
+    namespaces:
+      names:
+      - default
+  relabel_configs:
+  - action: labelmap
+    regex: __meta_kubernetes_pod_label_(.+)
+  - source_labels: [__meta_kubernetes_pod_uid]
+    target_label: __path__
+`,
+		},
+		{
+			name: "case 17: labels of a privileged namespace still checked",
+			config: PolicyConfig{
+				PrivilegedNamespaces: []string{"kube-system"},
+				DeniedLabels:         DefaultDeniedLabels,
+			},
+			key: Key{Namespace: "kube-system"},
+			snippet: `
+- job_name: kube-system/app
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - labels:
+      trace_id:
+`,
+			errorMatches: []string{"job `kube-system/app`: label `trace_id` must not be promoted"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewPolicy(tc.config)
+			if err != nil {
+				t.Fatalf("expected no error creating the policy, got %#q", err)
+			}
+			snippet, err := ParseSnippet(tc.snippet)
+			if err != nil {
+				t.Fatalf("expected no error parsing the snippet, got %#q", err)
+			}
+
+			err = policy.Check(tc.key, snippet)
+
+			if len(tc.errorMatches) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %#q", err)
+				}
+				return
+			}
+			if !IsInvalidSnippet(err) {
+				t.Fatalf("expected invalid snippet error, got %#v", err)
+			}
+			for _, m := range tc.errorMatches {
+				if !strings.Contains(err.Error(), m) {
+					t.Errorf("expected error to contain %#q, got %#q", m, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func Test_Policy_Restrict(t *testing.T) {
+	stages := `
+- docker: {}
+- match:
+    selector: '{app="web"}'
+    stages:
+    - regex:
+        expression: '^(?P<level>\w+)'
+    - labels:
+        level:
+`
+	var pipelineStages []promtail.PipelineStage
+	err := yaml.Unmarshal([]byte(stages), &pipelineStages)
+	if err != nil {
+		t.Fatalf("expected no error parsing the stages, got %#q", err)
+	}
+
+	keepApp := promtail.RelabelConfig{
+		Action:       "keep",
+		Regex:        "web",
+		SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+	}
+	keepTeam := promtail.RelabelConfig{
+		Action:       "keep",
+		Regex:        "team",
+		SourceLabels: []string{metaNamespace},
+	}
+	teamOnly := &promtail.KubernetesNamespaces{
+		Names: []string{"team"},
+	}
+
+	testCases := []struct {
+		name     string
+		config   PolicyConfig
+		key      Key
+		jobs     []promtail.ScrapeConfig
+		expected []promtail.ScrapeConfig
+	}{
+		{
+			name: "case 0: no jobs",
+			key:  Key{Namespace: "team"},
+		},
+		{
+			name: "case 1: job restricted to the pods of its namespace",
+			key:  Key{Namespace: "team"},
+			jobs: []promtail.ScrapeConfig{
+				{
+					JobName: "team/web",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod"},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{keepApp},
+					PipelineStages: pipelineStages,
+				},
+			},
+			expected: []promtail.ScrapeConfig{
+				{
+					JobName: "team/web",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod", Namespaces: teamOnly},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{keepTeam, keepApp, newPathRelabelConfig()},
+					PipelineStages: pipelineStages,
+				},
+			},
+		},
+		{
+			name: "case 2: namespaces selected by the job replaced",
+			key:  Key{Namespace: "team"},
+			jobs: []promtail.ScrapeConfig{
+				{
+					JobName: "team/other",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod", Namespaces: &promtail.KubernetesNamespaces{Names: []string{"kube-system", "team"}}},
+						{Role: "pod", Namespaces: &promtail.KubernetesNamespaces{Names: []string{"default"}}},
+					},
+				},
+			},
+			expected: []promtail.ScrapeConfig{
+				{
+					JobName: "team/other",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod", Namespaces: teamOnly},
+						{Role: "pod", Namespaces: teamOnly},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{keepTeam, newPathRelabelConfig()},
+				},
+			},
+		},
+		{
+			name: "case 3: path set by the job overridden",
+			key:  Key{Namespace: "team"},
+			jobs: []promtail.ScrapeConfig{
+				{
+					JobName: "team/path",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod"},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{
+						{Replacement: "/etc/*", TargetLabel: pathLabel},
+					},
+				},
+			},
+			expected: []promtail.ScrapeConfig{
+				{
+					JobName: "team/path",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod", Namespaces: teamOnly},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{
+						keepTeam,
+						{Replacement: "/etc/*", TargetLabel: pathLabel},
+						newPathRelabelConfig(),
+					},
+				},
+			},
+		},
+		{
+			name: "case 4: jobs of a privileged namespace returned as they are",
+			config: PolicyConfig{
+				PrivilegedNamespaces: []string{"kube-system"},
+			},
+			key: Key{Namespace: "kube-system"},
+			jobs: []promtail.ScrapeConfig{
+				{
+					JobName: "kube-system/nodes",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "node"},
+						{Role: "pod", Namespaces: &promtail.KubernetesNamespaces{Names: []string{"default"}}},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{
+						{Action: "labelmap", Regex: "__meta_kubernetes_pod_label_(.+)"},
+					},
+					PipelineStages: pipelineStages,
+				},
+			},
+			expected: []promtail.ScrapeConfig{
+				{
+					JobName: "kube-system/nodes",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "node"},
+						{Role: "pod", Namespaces: &promtail.KubernetesNamespaces{Names: []string{"default"}}},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{
+						{Action: "labelmap", Regex: "__meta_kubernetes_pod_label_(.+)"},
+					},
+					PipelineStages: pipelineStages,
+				},
+			},
+		},
+		{
+			name: "case 5: jobs of other namespaces restricted despite privileged ones",
+			config: PolicyConfig{
+				PrivilegedNamespaces: []string{"kube-system"},
+			},
+			key: Key{Namespace: "team"},
+			jobs: []promtail.ScrapeConfig{
+				{
+					JobName: "team/web",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod"},
+					},
+				},
+			},
+			expected: []promtail.ScrapeConfig{
+				{
+					JobName: "team/web",
+					KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+						{Role: "pod", Namespaces: teamOnly},
+					},
+					RelabelConfigs: []promtail.RelabelConfig{keepTeam, newPathRelabelConfig()},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewPolicy(tc.config)
+			if err != nil {
+				t.Fatalf("expected no error creating the policy, got %#q", err)
+			}
+
+			restricted := policy.Restrict(tc.key, tc.jobs)
+
+			if !reflect.DeepEqual(restricted, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, restricted)
+			}
+		})
+	}
+}
+
+func Test_NewPolicy(t *testing.T) {
+	testCases := []struct {
+		name         string
+		config       PolicyConfig
+		errorMatcher func(error) bool
+	}{
+		{
+			name:   "case 0: no limits",
+			config: PolicyConfig{},
+		},
+		{
+			name: "case 1: zero maximum",
+			config: PolicyConfig{
+				MaxLabels: 0,
+			},
+		},
+		{
+			name: "case 2: negative maximum",
+			config: PolicyConfig{
+				MaxLabels: -1,
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewPolicy(tc.config)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+		})
+	}
+}
@@ -1,37 +1,39 @@
 package promtailconfig
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"strings"
 
 	"github.com/giantswarm/k8sclient"
 	"github.com/giantswarm/microerror"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/giantswarm/loki-operator/pkg/project"
 )
 
 const (
-	nsHeader        = "# loki-operator.namespace"
-	containerHeader = "# loki-operator.container"
-	labelsHeader    = "# loki-operator.labels"
-	configMapHeader = `# this config is auto-generated by loki-operator - manual changes WILL BE LOST
-client:
-  backoff_config:
-    maxbackoff: 5s
-    maxretries: 20
-    minbackoff: 100ms
-  batchsize: 102400
-  batchwait: 1s
-  external_labels: {}
-  timeout: 10s
-positions:
-  filename: /run/promtail/positions.yaml
-server:
-  http_listen_port: 3101
-target_config:
-  sync_period: 10s
-scrape_configs:
-`
+	// ChecksumAnnotation is the annotation of promtail's config map holding
+	// the sha256 checksum of promtail's config.
+	ChecksumAnnotation = "loki-operator.giantswarm.io/config-checksum"
+	// OwnedKeysAnnotation is the annotation of promtail's config map listing
+	// the keys written by the operator, separated by commas. Other tools
+	// managing the config map, like Helm, must leave them alone.
+	OwnedKeysAnnotation = "loki-operator.giantswarm.io/owned-keys"
+
+	// managedByLabel marks promtail's config map as created by the
+	// operator.
+	managedByLabel = "app.kubernetes.io/managed-by"
+
+	nsHeader         = "# loki-operator.namespace"
+	containerHeader  = "# loki-operator.container"
+	labelsHeader     = "# loki-operator.labels"
+	workloadHeader   = "# loki-operator.workload"
+	generatedComment = "# this config is auto-generated by loki-operator - manual changes WILL BE LOST"
+	scrapeConfigsKey = "scrape_configs:"
 )
 
 type PromtailConfigMap struct {
@@ -39,6 +41,10 @@ type PromtailConfigMap struct {
 	namespace     string
 	name          string
 	configKeyName string
+
+	// Renderer renders the config written to the config map. Its setters
+	// configure the config map.
+	*Renderer
 }
 
 func NewPromtailConfigMap(k8sClient k8sclient.Interface, namespace, name, configKeyName string) (*PromtailConfigMap, error) {
@@ -67,132 +73,166 @@ func NewPromtailConfigMap(k8sClient k8sclient.Interface, namespace, name, config
 		namespace:     namespace,
 		name:          name,
 		configKeyName: configKeyName,
+		Renderer:      NewRenderer(),
 	}, nil
 }
 
+// Load returns the snippets rendered into promtail's config. They are read from
+// the state key, promtail's config itself is never parsed. ConfigMaps written
+// before the state key existed are migrated by reading the Keys from the
+// comments preceding their jobs in promtail's config.
 func (p *PromtailConfigMap) Load() (map[Key]string, error) {
 	cm, err := p.loadConfigMap()
-	if err != nil {
+	if errors.IsNotFound(microerror.Cause(err)) {
+		// The config map is created with the first write.
+		return map[Key]string{}, nil
+	} else if err != nil {
 		return nil, err
 	}
-	config, found := cm.Data[p.configKeyName]
-	if !found {
-		// TODO: log here
-		return nil, nil
-	}
-	lines := strings.Split(config, "\n")
 
-	res := make(map[Key]string)
-	startLineIndex := -1
-	for startLineIndex = range lines {
-		if lines[startLineIndex] == "scrape_configs:" {
-			startLineIndex++
-			break
-		}
-	}
-	for startLineIndex < len(lines) {
-		key, err := p.parseKey(lines, startLineIndex)
+	content, found := cm.Data[StateKeyName]
+	if found {
+		snippets, err := decodeState(content)
 		if err != nil {
-			return nil, nil
-		}
-		startLineIndex += 3
-		var nextStart int
-		for nextStart = startLineIndex; nextStart < len(lines); nextStart++ {
-			if strings.HasPrefix(lines[nextStart], containerHeader) {
-				break
-			}
+			return nil, microerror.Mask(err)
 		}
-		cfg := strings.Join(lines[startLineIndex:nextStart], "\n")
-		res[key] = cfg
-		startLineIndex = nextStart
+		return snippets, nil
 	}
-	return res, nil
-}
 
-func (p *PromtailConfigMap) parseKey(lines []string, startIndex int) (Key, error) {
-	if !(strings.HasPrefix(lines[startIndex], containerHeader) &&
-		strings.HasPrefix(lines[startIndex+1], nsHeader) &&
-		strings.HasPrefix(lines[startIndex+2], labelsHeader)) {
-		return Key{}, microerror.New("Couldn't find expected header")
+	config, found := cm.Data[p.configKeyName]
+	if !found {
+		return map[Key]string{}, nil
 	}
-	key := Key{
-		ContainerName: lines[startIndex][len(containerHeader)+1:],
-		Namespace:     lines[startIndex+1][len(nsHeader)+1:],
-		Labels:        lines[startIndex+2][len(labelsHeader)+1:],
+	snippets, err := decodeLegacyState(config)
+	if err != nil {
+		return nil, microerror.Mask(err)
 	}
-	return key, nil
+
+	return snippets, nil
 }
 
-func (p *PromtailConfigMap) Update(newSnippets map[Key]string) error {
-	oldSnippets, err := p.Load()
+// Update writes newSnippets to the config map, unless it already holds them.
+// It returns whether the config map was written. Changes are detected by
+// comparing the checksum of the rendered config with the checksum of the
+// config held by the config map and with its ChecksumAnnotation, so configs
+// edited by hand are overwritten as well.
+//
+// The config map is created when it doesn't exist. Otherwise only the fields
+// owned by the operator, i.e. the config key, StateKeyName,
+// ChecksumAnnotation and OwnedKeysAnnotation, are changed, everything else is
+// left as it is. The operator expects to co-own a config map managed by Helm,
+// e.g. the one of promtail's chart. OwnedKeysAnnotation records the keys it
+// owns, so the chart must not template them. When another tool overwrites
+// them anyway, the checksum no longer matches and they are written again. The
+// write is made against the resourceVersion read, so concurrent changes are
+// never lost. Conflicting writes are retried with backoff.
+func (p *PromtailConfigMap) Update(newSnippets map[Key]string) (bool, error) {
+	config, err := p.Render(newSnippets)
 	if err != nil {
-		return err
+		return false, microerror.Mask(err)
 	}
+	state, err := encodeState(newSnippets)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+	sum := checksum(config)
 
-	updateNeeded := false
-	if len(oldSnippets) != len(newSnippets) {
-		updateNeeded = true
-	} else {
-		var oldKeysSorted, newKeysSorted []Key
-		for k, _ := range oldSnippets {
-			oldKeysSorted = append(oldKeysSorted, k)
-		}
-		for k, _ := range newSnippets {
-			newKeysSorted = append(newKeysSorted, k)
-		}
-		SortKeys(oldKeysSorted)
-		SortKeys(newKeysSorted)
-		for i := range newKeysSorted {
-			// if relevant keys or values for these keys differ, we need to update
-			keysNotEqual := oldKeysSorted[i] != newKeysSorted[i]
-			valsNotEqual := oldSnippets[oldKeysSorted[i]] != newSnippets[newKeysSorted[i]]
-			if keysNotEqual || valsNotEqual {
-				updateNeeded = true
-				break
+	var written bool
+	err = retry.OnError(retry.DefaultBackoff, isWriteConflict, func() error {
+		written = false
+
+		cm, err := p.k8sClient.K8sClient().CoreV1().ConfigMaps(p.namespace).Get(p.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			cm = p.newConfigMap()
+			setOwnedFields(cm, p.configKeyName, config, state, sum)
+			_, err = p.k8sClient.K8sClient().CoreV1().ConfigMaps(p.namespace).Create(cm)
+			if err != nil {
+				return err
 			}
+			written = true
+			return nil
+		} else if err != nil {
+			return err
 		}
-	}
 
-	if !updateNeeded {
+		if cm.Annotations[ChecksumAnnotation] == sum && checksum(cm.Data[p.configKeyName]) == sum &&
+			cm.Data[StateKeyName] == state && cm.Annotations[OwnedKeysAnnotation] == ownedKeys(p.configKeyName) {
+			return nil
+		}
+
+		setOwnedFields(cm, p.configKeyName, config, state, sum)
+		_, err = p.k8sClient.K8sClient().CoreV1().ConfigMaps(p.namespace).Update(cm)
+		if err != nil {
+			return err
+		}
+		written = true
 		return nil
+	})
+	if err != nil {
+		return false, microerror.Maskf(err, "Couldn't update promtail configmap %s/%s", p.namespace, p.name)
 	}
-	return p.save(newSnippets)
+
+	return written, nil
 }
 
-func (p *PromtailConfigMap) loadConfigMap() (*v1.ConfigMap, error) {
-	cm, err := p.k8sClient.K8sClient().CoreV1().ConfigMaps(p.namespace).Get(p.name, metav1.GetOptions{})
+// Checksum returns the sha256 checksum of the promtail config currently held
+// by the config map.
+func (p *PromtailConfigMap) Checksum() (string, error) {
+	cm, err := p.loadConfigMap()
 	if err != nil {
-		return nil, microerror.Maskf(err, "Couldn't load configmap %s/%s", p.namespace, p.name)
+		return "", err
 	}
-	return cm, nil
-}
 
-func (p *PromtailConfigMap) render(key Key, snippet string) string {
-	var config strings.Builder
-	config.WriteString(fmt.Sprintf("%s %s\n", containerHeader, key.ContainerName))
-	config.WriteString(fmt.Sprintf("%s %s\n", nsHeader, key.Namespace))
-	config.WriteString(fmt.Sprintf("%s %s\n", labelsHeader, key.Labels))
-	config.WriteString(snippet)
+	return checksum(cm.Data[p.configKeyName]), nil
+}
 
-	return config.String()
+// newConfigMap returns the config map to create when it doesn't exist yet.
+func (p *PromtailConfigMap) newConfigMap() *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.name,
+			Namespace: p.namespace,
+			Labels: map[string]string{
+				managedByLabel: project.Name(),
+			},
+		},
+	}
 }
 
-func (p *PromtailConfigMap) save(snippets map[Key]string) error {
-	var config strings.Builder
-	config.WriteString(configMapHeader)
-	for key, snippet := range snippets {
-		config.WriteString(p.render(key, snippet))
+// setOwnedFields sets the fields of cm owned by the operator.
+func setOwnedFields(cm *v1.ConfigMap, configKeyName, config, state, sum string) {
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
 	}
+	cm.Data[configKeyName] = config
+	cm.Data[StateKeyName] = state
 
-	cm, err := p.loadConfigMap()
-	if err != nil {
-		return err
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
 	}
+	cm.Annotations[ChecksumAnnotation] = sum
+	cm.Annotations[OwnedKeysAnnotation] = ownedKeys(configKeyName)
+}
+
+// ownedKeys returns the value of OwnedKeysAnnotation.
+func ownedKeys(configKeyName string) string {
+	return strings.Join([]string{configKeyName, StateKeyName}, ",")
+}
+
+// isWriteConflict tells whether the config map was changed or created
+// concurrently, which is resolved by reading and writing it again.
+func isWriteConflict(err error) bool {
+	return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+}
 
-	cm.Data[p.configKeyName] = config.String()
-	if _, err := p.k8sClient.K8sClient().CoreV1().ConfigMaps(p.namespace).Update(cm); err != nil {
-		return microerror.Maskf(err, "Couldn't update promtail configmap %s/%s", p.namespace, p.name)
+func (p *PromtailConfigMap) loadConfigMap() (*v1.ConfigMap, error) {
+	cm, err := p.k8sClient.K8sClient().CoreV1().ConfigMaps(p.namespace).Get(p.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, microerror.Maskf(err, "Couldn't load configmap %s/%s", p.namespace, p.name)
 	}
+	return cm, nil
+}
 
-	return nil
+func checksum(config string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(config)))
 }
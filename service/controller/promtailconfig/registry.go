@@ -0,0 +1,179 @@
+package promtailconfig
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Registry keeps track of the snippets registered for every Key together with
+// the sources contributing them. Sources are the pods or PromtailConfigs
+// identified by their UID. All replicas of a workload usually share the same
+// Key, so a Key stays registered until its last contributing source is gone.
+// Registry is safe for concurrent use.
+type Registry struct {
+	entries  map[Key]*registryEntry
+	mutex    sync.Mutex
+	sequence uint64
+}
+
+type registryEntry struct {
+	// contributions holds the snippet registered by each source.
+	contributions map[types.UID]contribution
+}
+
+// Registration is a snippet registered for a Key by a source.
+type Registration struct {
+	Key     Key
+	Source  types.UID
+	Snippet string
+}
+
+type contribution struct {
+	snippet string
+	// sequence orders the contributions by the time they were registered.
+	sequence uint64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		entries: make(map[Key]*registryEntry),
+	}
+}
+
+// Register records the snippet contributed by source for key. When sources of
+// the same key contribute different snippets, the snippet registered last
+// wins and a snippetConflictError is returned. The snippet is registered
+// anyway, as pods of a workload being rolled out may disagree for a while.
+func (r *Registry) Register(key Key, source types.UID, snippet string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, found := r.entries[key]
+	if !found {
+		entry = &registryEntry{
+			contributions: make(map[types.UID]contribution),
+		}
+		r.entries[key] = entry
+	}
+
+	r.sequence++
+	entry.contributions[source] = contribution{
+		snippet:  snippet,
+		sequence: r.sequence,
+	}
+
+	var conflicting []string
+	for uid, c := range entry.contributions {
+		if c.snippet != snippet {
+			conflicting = append(conflicting, string(uid))
+		}
+	}
+	if len(conflicting) > 0 {
+		sort.Strings(conflicting)
+		return microerror.Maskf(snippetConflictError, "snippet of %s for key %v differs from the snippets of %v",
+			source, key, conflicting)
+	}
+
+	return nil
+}
+
+// Unregister removes the snippet contributed by source for key. The key
+// itself is removed once its last source is unregistered.
+func (r *Registry) Unregister(key Key, source types.UID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, found := r.entries[key]
+	if !found {
+		return
+	}
+
+	delete(entry.contributions, source)
+	if len(entry.contributions) == 0 {
+		delete(r.entries, key)
+	}
+}
+
+// UnregisterSource removes all the snippets contributed by source.
+func (r *Registry) UnregisterSource(source types.UID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, entry := range r.entries {
+		delete(entry.contributions, source)
+		if len(entry.contributions) == 0 {
+			delete(r.entries, key)
+		}
+	}
+}
+
+// RetainSource removes the snippets contributed by source for Keys other than
+// keys, e.g. because the labels of the source changed its Keys.
+func (r *Registry) RetainSource(source types.UID, keys []Key) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	retained := make(map[Key]bool, len(keys))
+	for _, key := range keys {
+		retained[key] = true
+	}
+
+	for key, entry := range r.entries {
+		if retained[key] {
+			continue
+		}
+		delete(entry.contributions, source)
+		if len(entry.contributions) == 0 {
+			delete(r.entries, key)
+		}
+	}
+}
+
+// Snippets returns a snapshot of the snippets to render for all registered
+// keys.
+func (r *Registry) Snippets() map[Key]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	snippets := make(map[Key]string, len(r.entries))
+	for key, entry := range r.entries {
+		snippets[key] = entry.snippet()
+	}
+
+	return snippets
+}
+
+// Registrations returns a snapshot of the snippets registered by every source
+// for every key.
+func (r *Registry) Registrations() []Registration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var registrations []Registration
+	for key, entry := range r.entries {
+		for source, c := range entry.contributions {
+			registrations = append(registrations, Registration{
+				Key:     key,
+				Source:  source,
+				Snippet: c.snippet,
+			})
+		}
+	}
+
+	return registrations
+}
+
+// snippet returns the snippet registered last for the entry.
+func (e *registryEntry) snippet() string {
+	var latest contribution
+	for _, c := range e.contributions {
+		if c.sequence > latest.sequence {
+			latest = c
+		}
+	}
+
+	return latest.snippet
+}
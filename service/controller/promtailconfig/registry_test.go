@@ -0,0 +1,251 @@
+package promtailconfig
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	registryKeyWeb = Key{Namespace: "default", Workload: "Deployment/web", Labels: "app=web", ContainerName: "nginx"}
+	registryKeyAPI = Key{Namespace: "default", Workload: "Deployment/api", Labels: "app=api", ContainerName: "api"}
+)
+
+// registryOp is an operation applied to the Registry under test.
+type registryOp func(r *Registry) error
+
+func registerOp(key Key, source types.UID, snippet string) registryOp {
+	return func(r *Registry) error {
+		return r.Register(key, source, snippet)
+	}
+}
+
+func unregisterOp(key Key, source types.UID) registryOp {
+	return func(r *Registry) error {
+		r.Unregister(key, source)
+		return nil
+	}
+}
+
+func unregisterSourceOp(source types.UID) registryOp {
+	return func(r *Registry) error {
+		r.UnregisterSource(source)
+		return nil
+	}
+}
+
+func retainSourceOp(source types.UID, keys ...Key) registryOp {
+	return func(r *Registry) error {
+		r.RetainSource(source, keys)
+		return nil
+	}
+}
+
+func Test_Registry(t *testing.T) {
+	testCases := []struct {
+		name string
+		ops  []registryOp
+		// conflicts are the indexes of the ops expected to return a snippet
+		// conflict error.
+		conflicts             []int
+		expectedSnippets      map[Key]string
+		expectedRegistrations []Registration
+	}{
+		{
+			name:                  "case 0: empty registry",
+			expectedSnippets:      map[Key]string{},
+			expectedRegistrations: nil,
+		},
+		{
+			name: "case 1: sources of a key contributing the same snippet",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "web"),
+				registerOp(registryKeyWeb, "pod-2", "web"),
+				registerOp(registryKeyAPI, "pod-3", "api"),
+			},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "web",
+				registryKeyAPI: "api",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "web"},
+				{Key: registryKeyWeb, Source: "pod-2", Snippet: "web"},
+				{Key: registryKeyAPI, Source: "pod-3", Snippet: "api"},
+			},
+		},
+		{
+			name: "case 2: last registered snippet wins a conflict",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "old"),
+				registerOp(registryKeyWeb, "pod-2", "new"),
+			},
+			conflicts: []int{1},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "new",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "old"},
+				{Key: registryKeyWeb, Source: "pod-2", Snippet: "new"},
+			},
+		},
+		{
+			name: "case 3: re-registering a snippet makes it win again",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "old"),
+				registerOp(registryKeyWeb, "pod-2", "new"),
+				registerOp(registryKeyWeb, "pod-1", "old"),
+			},
+			conflicts: []int{1, 2},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "old",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "old"},
+				{Key: registryKeyWeb, Source: "pod-2", Snippet: "new"},
+			},
+		},
+		{
+			name: "case 4: snippet of the remaining source rendered after the winner is unregistered",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "old"),
+				registerOp(registryKeyWeb, "pod-2", "new"),
+				unregisterOp(registryKeyWeb, "pod-2"),
+			},
+			conflicts: []int{1},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "old",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "old"},
+			},
+		},
+		{
+			name: "case 5: key removed with its last source",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "web"),
+				registerOp(registryKeyWeb, "pod-2", "web"),
+				unregisterOp(registryKeyWeb, "pod-1"),
+				unregisterOp(registryKeyWeb, "pod-2"),
+			},
+			expectedSnippets:      map[Key]string{},
+			expectedRegistrations: nil,
+		},
+		{
+			name: "case 6: unregistering an unknown key or source",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "web"),
+				unregisterOp(registryKeyAPI, "pod-1"),
+				unregisterOp(registryKeyWeb, "pod-2"),
+			},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "web",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "web"},
+			},
+		},
+		{
+			name: "case 7: source unregistered from all its keys",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "config-1", "web"),
+				registerOp(registryKeyAPI, "config-1", "api"),
+				registerOp(registryKeyAPI, "pod-1", "api"),
+				unregisterSourceOp("config-1"),
+			},
+			expectedSnippets: map[Key]string{
+				registryKeyAPI: "api",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyAPI, Source: "pod-1", Snippet: "api"},
+			},
+		},
+		{
+			name: "case 8: source retained for some of its keys",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "config-1", "web"),
+				registerOp(registryKeyAPI, "config-1", "api"),
+				registerOp(registryKeyWeb, "pod-1", "web"),
+				retainSourceOp("config-1", registryKeyAPI),
+			},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "web",
+				registryKeyAPI: "api",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "web"},
+				{Key: registryKeyAPI, Source: "config-1", Snippet: "api"},
+			},
+		},
+		{
+			name: "case 9: source retained for no keys",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "config-1", "web"),
+				registerOp(registryKeyAPI, "config-1", "api"),
+				retainSourceOp("config-1"),
+			},
+			expectedSnippets:      map[Key]string{},
+			expectedRegistrations: nil,
+		},
+		{
+			name: "case 10: retaining other sources' keys doesn't register them",
+			ops: []registryOp{
+				registerOp(registryKeyWeb, "pod-1", "web"),
+				registerOp(registryKeyAPI, "pod-2", "api"),
+				retainSourceOp("pod-1", registryKeyWeb, registryKeyAPI),
+			},
+			expectedSnippets: map[Key]string{
+				registryKeyWeb: "web",
+				registryKeyAPI: "api",
+			},
+			expectedRegistrations: []Registration{
+				{Key: registryKeyWeb, Source: "pod-1", Snippet: "web"},
+				{Key: registryKeyAPI, Source: "pod-2", Snippet: "api"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry()
+
+			conflicts := map[int]bool{}
+			for _, i := range tc.conflicts {
+				conflicts[i] = true
+			}
+			for i, op := range tc.ops {
+				err := op(r)
+				switch {
+				case conflicts[i] && !IsSnippetConflict(err):
+					t.Fatalf("expected snippet conflict error for op %d, got %#v", i, err)
+				case !conflicts[i] && err != nil:
+					t.Fatalf("expected no error for op %d, got %#q", i, err)
+				}
+			}
+
+			snippets := r.Snippets()
+			if !reflect.DeepEqual(snippets, tc.expectedSnippets) {
+				t.Fatalf("expected snippets %#v, got %#v", tc.expectedSnippets, snippets)
+			}
+
+			registrations := r.Registrations()
+			sortRegistrations(registrations)
+			sortRegistrations(tc.expectedRegistrations)
+			if !reflect.DeepEqual(registrations, tc.expectedRegistrations) {
+				t.Fatalf("expected registrations %#v, got %#v", tc.expectedRegistrations, registrations)
+			}
+		})
+	}
+}
+
+func sortRegistrations(registrations []Registration) {
+	sort.Slice(registrations, func(i, j int) bool {
+		if registrations[i].Key != registrations[j].Key {
+			keys := []Key{registrations[i].Key, registrations[j].Key}
+			SortKeys(keys)
+			return keys[0] == registrations[i].Key
+		}
+		return registrations[i].Source < registrations[j].Source
+	})
+}
@@ -0,0 +1,272 @@
+package promtailconfig
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+// defaultBaseConfig returns the part of promtail's config not contributed by
+// snippets, used unless a base config is configured. It has no client URL,
+// which has to be passed to promtail with -client.url then.
+func defaultBaseConfig() promtail.Config {
+	return promtail.Config{
+		Client: &promtail.ClientConfig{
+			BackoffConfig: &promtail.BackoffConfig{
+				MaxBackoff: "5s",
+				MaxRetries: 20,
+				MinBackoff: "100ms",
+			},
+			BatchSize: 102400,
+			BatchWait: "1s",
+			Timeout:   "10s",
+		},
+		Positions: &promtail.PositionsConfig{
+			Filename: "/run/promtail/positions.yaml",
+		},
+		Server: &promtail.ServerConfig{
+			HTTPListenPort: 3101,
+		},
+		TargetConfig: &promtail.TargetConfig{
+			SyncPeriod: "10s",
+		},
+	}
+}
+
+// TenantResolver tells the Loki tenant the logs of a namespace are sent to.
+type TenantResolver interface {
+	// Tenant returns the tenant of namespace, or an empty string when the
+	// logs are sent to the tenant of promtail's client.
+	Tenant(namespace string) string
+}
+
+// Renderer renders promtail's config from the snippets of all Keys. It needs
+// no access to the cluster, so configs can be rendered offline as well.
+type Renderer struct {
+	// baseMutex guards base and mandatory.
+	baseMutex sync.Mutex
+	// base is the part of promtail's config the scrape configs are merged
+	// into. Nothing is rendered while it is nil.
+	base *promtail.Config
+	// mandatory holds the stages put at the start and the end of every job.
+	mandatory promtail.MandatoryStages
+
+	// policy restricts the scrape configs of snippets.
+	policy *Policy
+	// tenants resolves the tenant stage appended to the jobs of every Key.
+	// No tenant stage is rendered when it is nil.
+	tenants TenantResolver
+}
+
+// NewRenderer returns a Renderer using the default base config, no mandatory
+// stages and a policy privileging no namespace and not restricting labels.
+func NewRenderer() *Renderer {
+	base := defaultBaseConfig()
+
+	r := &Renderer{
+		base:   &base,
+		policy: &Policy{},
+	}
+
+	return r
+}
+
+// SetBase replaces the base config the scrape configs are merged into. The
+// base must have passed promtail.ValidateBaseConfig. Setting nil holds back
+// all writes until a base is set, Render returns an error matched by
+// IsBaseConfigMissing until then.
+func (r *Renderer) SetBase(base *promtail.Config) {
+	r.baseMutex.Lock()
+	defer r.baseMutex.Unlock()
+
+	r.base = base
+}
+
+// SetMandatoryStages replaces the stages put at the start and at the end of
+// every job. They must have passed promtail.ValidateMandatoryStages. Setting
+// nil removes them.
+func (r *Renderer) SetMandatoryStages(mandatory *promtail.MandatoryStages) {
+	r.baseMutex.Lock()
+	defer r.baseMutex.Unlock()
+
+	r.mandatory = promtail.MandatoryStages{}
+	if mandatory != nil {
+		r.mandatory = *mandatory
+	}
+}
+
+// SetPolicy replaces the policy snippets are checked against. By default no
+// namespace is privileged and labels aren't restricted. It must be called
+// before the first snippet is registered.
+func (r *Renderer) SetPolicy(policy *Policy) {
+	r.policy = policy
+}
+
+// SetTenantResolver makes every job end with a tenant stage setting the tenant
+// resolved for the namespace of its Key. Being the last stage, it overrides
+// tenants set by the snippets. It must be called before the first Render.
+func (r *Renderer) SetTenantResolver(tenants TenantResolver) {
+	r.tenants = tenants
+}
+
+// Render marshals the whole promtail config. The jobs of every Key are
+// preceded by comments identifying the Key for humans reading the config.
+// Keys are rendered in order, so the same snippets always render the same
+// config.
+func (r *Renderer) Render(snippets map[Key]string) (string, error) {
+	r.baseMutex.Lock()
+	base := r.base
+	mandatory := r.mandatory
+	r.baseMutex.Unlock()
+	if base == nil {
+		return "", microerror.Maskf(baseConfigMissingError, "base of promtail's config not loaded yet")
+	}
+
+	b, err := base.Marshal()
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	var config strings.Builder
+	config.WriteString(generatedComment)
+	config.WriteString("\n")
+	config.Write(b)
+
+	if len(snippets) == 0 {
+		config.WriteString(scrapeConfigsKey)
+		config.WriteString(" []\n")
+		return config.String(), nil
+	}
+
+	var keys []Key
+	for key := range snippets {
+		keys = append(keys, key)
+	}
+	SortKeys(keys)
+
+	config.WriteString(scrapeConfigsKey)
+	config.WriteString("\n")
+	for _, key := range keys {
+		rendered, err := r.renderJobs(key, snippets[key], mandatory)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		config.WriteString(rendered)
+	}
+
+	return config.String(), nil
+}
+
+// Accept returns the canonical form of the snippet content registered for
+// key. Snippets which can't be parsed or rendered for key, or violate the
+// policy, are rejected with an error matched by IsInvalidSnippet.
+func (r *Renderer) Accept(key Key, content string) (string, error) {
+	snippet, err := ParseSnippet(content)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	_, _, err = r.jobs(key, snippet, promtail.MandatoryStages{})
+	if IsInvalidSnippet(err) {
+		return "", microerror.Mask(err)
+	} else if err != nil {
+		return "", microerror.Maskf(invalidSnippetError, "%v", err)
+	}
+	canonical, err := snippet.String()
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return canonical, nil
+}
+
+// renderJobs renders the comments identifying key followed by the jobs of
+// its snippet. The stages injected by the operator are marked with comments.
+func (r *Renderer) renderJobs(key Key, content string, mandatory promtail.MandatoryStages) (string, error) {
+	snippet, err := ParseSnippet(content)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	jobs, injected, err := r.jobs(key, snippet, mandatory)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	var config strings.Builder
+	config.WriteString(fmt.Sprintf("%s %s\n", containerHeader, key.ContainerName))
+	config.WriteString(fmt.Sprintf("%s %s\n", nsHeader, key.Namespace))
+	config.WriteString(fmt.Sprintf("%s %s\n", labelsHeader, key.Labels))
+	if key.Workload != "" {
+		config.WriteString(fmt.Sprintf("%s %s\n", workloadHeader, key.Workload))
+	}
+	for _, job := range jobs {
+		rendered, err := job.RenderMarked(injected.comments(len(job.PipelineStages)))
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+		config.WriteString(rendered)
+	}
+
+	return config.String(), nil
+}
+
+// jobs returns the jobs rendered for the snippet of key. Scrape configs of
+// snippets are checked against and restricted by the policy. The mandatory
+// stages are put at the start and the end of the jobs, which end with a stage
+// setting the tenant of the namespace of key, if any. Snippets of such keys
+// must not set the tenant themselves. The stages injected this way are
+// described by the returned injection.
+func (r *Renderer) jobs(key Key, snippet *Snippet, mandatory promtail.MandatoryStages) ([]promtail.ScrapeConfig, injection, error) {
+	err := r.policy.Check(key, snippet)
+	if err != nil {
+		return nil, injection{}, microerror.Mask(err)
+	}
+	jobs, err := snippet.Jobs(key)
+	if err != nil {
+		return nil, injection{}, microerror.Mask(err)
+	}
+	if snippet.ScrapeConfigs != nil {
+		jobs = r.policy.Restrict(key, jobs)
+	}
+
+	var tenant string
+	if r.tenants != nil {
+		tenant = r.tenants.Tenant(key.Namespace)
+	}
+	if tenant != "" {
+		for _, job := range jobs {
+			if promtail.HasStage(job.PipelineStages, "tenant") {
+				return nil, injection{}, microerror.Maskf(invalidSnippetError, "job %#q: tenant stages are not allowed, the tenant of namespace %#q is %#q", job.JobName, key.Namespace, tenant)
+			}
+		}
+	}
+
+	injected := injection{
+		prepended: len(mandatory.Prepend),
+		appended:  len(mandatory.Append),
+		tenant:    tenant != "",
+	}
+	for i := range jobs {
+		var stages []promtail.PipelineStage
+		stages = append(stages, mandatory.Prepend...)
+		stages = append(stages, jobs[i].PipelineStages...)
+		stages = append(stages, mandatory.Append...)
+		if tenant != "" {
+			stages = append(stages, newTenantStage(tenant))
+		}
+		jobs[i].PipelineStages = stages
+	}
+
+	return jobs, injected, nil
+}
+
+func newTenantStage(tenant string) promtail.PipelineStage {
+	return promtail.PipelineStage{
+		"tenant": map[string]interface{}{
+			"value": tenant,
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package promtailconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeTenants resolves the same tenant for every namespace.
+type fakeTenants string
+
+func (t fakeTenants) Tenant(namespace string) string {
+	return string(t)
+}
+
+func Test_Renderer_Accept_Stages(t *testing.T) {
+	key := Key{Namespace: "team", Labels: "app=web", ContainerName: "nginx"}
+
+	testCases := []struct {
+		name    string
+		tenants TenantResolver
+		snippet string
+		// errorMatch is part of the expected error message. No error is
+		// expected when it is empty.
+		errorMatch string
+	}{
+		{
+			name: "case 0: tenant stage passed without tenants",
+			snippet: `
+pipeline_stages:
+- tenant:
+    value: team-a
+`,
+		},
+		{
+			name:    "case 1: tenant stage passed with tenants disabled",
+			tenants: fakeTenants(""),
+			snippet: `
+pipeline_stages:
+- tenant:
+    value: team-a
+`,
+		},
+		{
+			name:    "case 2: snippet without tenant stage",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+pipeline_stages:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - labels:
+        level:
+`,
+		},
+		{
+			name:    "case 3: tenant stage rejected",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+pipeline_stages:
+- tenant:
+    value: team-a
+`,
+			errorMatch: "tenant stages are not allowed, the tenant of namespace `team` is `team-b`",
+		},
+		{
+			name:    "case 4: tenant stage nested in a match stage rejected",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+pipeline_stages:
+- match:
+    selector: '{app="web"}'
+    stages:
+    - tenant:
+        source: tenant
+`,
+			errorMatch: "tenant stages are not allowed",
+		},
+		{
+			name:    "case 5: tenant stage of a scrape config rejected",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+- job_name: team/web
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - tenant:
+      value: team-a
+`,
+			errorMatch: "job `team/web`: tenant stages are not allowed",
+		},
+		{
+			name:    "case 6: allowed stage types nested in a match stage of a scrape config",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+- job_name: team/web
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - cri: {}
+  - match:
+      selector: '{app="web"}'
+      stages:
+      - json:
+          expressions:
+            level: level
+      - labels:
+          level:
+`,
+		},
+		{
+			name:    "case 7: tenant stage nested in a match stage of a scrape config rejected",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+- job_name: team/web
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - match:
+      selector: '{app="web"}'
+      stages:
+      - match:
+          selector: '{app="web", tier="frontend"}'
+          stages:
+          - tenant:
+              value: team-a
+`,
+			errorMatch: "job `team/web`: tenant stages are not allowed",
+		},
+		{
+			name:    "case 8: unknown stage type nested in a match stage of a scrape config rejected",
+			tenants: fakeTenants("team-b"),
+			snippet: `
+- job_name: team/web
+  kubernetes_sd_configs:
+  - role: pod
+  pipeline_stages:
+  - match:
+      selector: '{app="web"}'
+      stages:
+      - geoip: {}
+`,
+			errorMatch: "geoip",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRenderer()
+			if tc.tenants != nil {
+				r.SetTenantResolver(tc.tenants)
+			}
+
+			_, err := r.Accept(key, tc.snippet)
+
+			if tc.errorMatch == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %#q", err)
+				}
+				return
+			}
+			if !IsInvalidSnippet(err) {
+				t.Fatalf("expected invalid snippet error, got %#v", err)
+			}
+			if !strings.Contains(err.Error(), tc.errorMatch) {
+				t.Fatalf("expected error to contain %#q, got %#q", tc.errorMatch, err.Error())
+			}
+		})
+	}
+}
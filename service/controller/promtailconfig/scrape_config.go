@@ -0,0 +1,148 @@
+package promtailconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+const (
+	metaNamespace          = "__meta_kubernetes_namespace"
+	metaPodName            = "__meta_kubernetes_pod_name"
+	metaPodUID             = "__meta_kubernetes_pod_uid"
+	metaContainerName      = "__meta_kubernetes_pod_container_name"
+	metaPodLabelPrefix     = "__meta_kubernetes_pod_label_"
+	metaPodLabelPresPrefix = "__meta_kubernetes_pod_labelpresent_"
+
+	// pathLabel holds the glob of the files promtail tails for a target.
+	pathLabel = "__path__"
+)
+
+var invalidLabelNameChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// NewScrapeConfig generates the service discovery and relabeling rules, which
+// restrict a scrape config to the container of the pods selected by key. The
+// Labels of the key must be a valid label selector.
+func NewScrapeConfig(key Key, pipelineStages []promtail.PipelineStage) (*promtail.ScrapeConfig, error) {
+	requirements, err := labels.ParseToRequirements(key.Labels)
+	if err != nil {
+		return nil, microerror.Maskf(invalidKeyError, "labels %#q of key are not a valid selector: %v", key.Labels, err)
+	}
+
+	relabelConfigs := []promtail.RelabelConfig{
+		newNamespaceRelabelConfig(key.Namespace),
+	}
+	for _, r := range requirements {
+		relabelConfigs = append(relabelConfigs, newRequirementRelabelConfig(r))
+	}
+	relabelConfigs = append(relabelConfigs,
+		promtail.RelabelConfig{
+			Action:       "keep",
+			Regex:        regexp.QuoteMeta(key.ContainerName),
+			SourceLabels: []string{metaContainerName},
+		},
+		promtail.RelabelConfig{
+			SourceLabels: []string{metaNamespace},
+			TargetLabel:  "namespace",
+		},
+		promtail.RelabelConfig{
+			SourceLabels: []string{metaPodName},
+			TargetLabel:  "instance",
+		},
+		promtail.RelabelConfig{
+			SourceLabels: []string{metaContainerName},
+			TargetLabel:  "container_name",
+		},
+		newPathRelabelConfig(),
+	)
+
+	s := &promtail.ScrapeConfig{
+		JobName: jobName(key),
+		KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+			{
+				Role: "pod",
+				Namespaces: &promtail.KubernetesNamespaces{
+					Names: []string{key.Namespace},
+				},
+			},
+		},
+		RelabelConfigs: relabelConfigs,
+		PipelineStages: pipelineStages,
+	}
+
+	return s, nil
+}
+
+// newNamespaceRelabelConfig keeps the targets of namespace only.
+func newNamespaceRelabelConfig(namespace string) promtail.RelabelConfig {
+	return promtail.RelabelConfig{
+		Action:       "keep",
+		Regex:        regexp.QuoteMeta(namespace),
+		SourceLabels: []string{metaNamespace},
+	}
+}
+
+// newPathRelabelConfig points promtail to the log files of the container of
+// the target pod.
+func newPathRelabelConfig() promtail.RelabelConfig {
+	return promtail.RelabelConfig{
+		Replacement:  "/var/log/pods/*$1/*.log",
+		Separator:    "/",
+		SourceLabels: []string{metaPodUID, metaContainerName},
+		TargetLabel:  pathLabel,
+	}
+}
+
+func newRequirementRelabelConfig(r labels.Requirement) promtail.RelabelConfig {
+	labelName := invalidLabelNameChars.ReplaceAllString(r.Key(), "_")
+
+	switch r.Operator() {
+	case selection.Exists:
+		return promtail.RelabelConfig{
+			Action:       "keep",
+			Regex:        "true",
+			SourceLabels: []string{metaPodLabelPresPrefix + labelName},
+		}
+	case selection.DoesNotExist:
+		return promtail.RelabelConfig{
+			Action:       "drop",
+			Regex:        "true",
+			SourceLabels: []string{metaPodLabelPresPrefix + labelName},
+		}
+	}
+
+	values := r.Values().List()
+	for i := range values {
+		values[i] = regexp.QuoteMeta(values[i])
+	}
+	sort.Strings(values)
+
+	action := "keep"
+	if r.Operator() == selection.NotIn || r.Operator() == selection.NotEquals {
+		action = "drop"
+	}
+
+	return promtail.RelabelConfig{
+		Action:       action,
+		Regex:        strings.Join(values, "|"),
+		SourceLabels: []string{metaPodLabelPrefix + labelName},
+	}
+}
+
+// jobName returns a readable job name, which is unique for every key.
+func jobName(key Key) string {
+	if key.Workload != "" {
+		return fmt.Sprintf("%s/%s/%s", key.Namespace, strings.ToLower(key.Workload), key.ContainerName)
+	}
+
+	sum := sha256.Sum256([]byte(key.Labels))
+	return fmt.Sprintf("%s/%s/%x", key.Namespace, key.ContainerName, sum[:4])
+}
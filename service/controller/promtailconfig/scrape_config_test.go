@@ -0,0 +1,278 @@
+package promtailconfig
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+func Test_NewScrapeConfig(t *testing.T) {
+	testCases := []struct {
+		name string
+		key  Key
+		// expectedRequirements are the relabel configs expected for the
+		// requirements of the key's labels.
+		expectedRequirements []promtail.RelabelConfig
+		expectedJobName      string
+		errorMatcher         func(error) bool
+	}{
+		{
+			name: "case 0: single equality requirement",
+			key: Key{
+				Namespace:     "default",
+				Workload:      "Deployment/web",
+				Labels:        "app=web",
+				ContainerName: "nginx",
+			},
+			expectedRequirements: []promtail.RelabelConfig{
+				{
+					Action:       "keep",
+					Regex:        "web",
+					SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+				},
+			},
+			expectedJobName: "default/deployment/web/nginx",
+		},
+		{
+			name: "case 1: requirements of all operators sorted by label",
+			key: Key{
+				Namespace:     "default",
+				Workload:      "Deployment/web",
+				Labels:        "tier in (frontend,backend),env notin (dev),app,!canary,zone!=eu",
+				ContainerName: "nginx",
+			},
+			expectedRequirements: []promtail.RelabelConfig{
+				{
+					Action:       "keep",
+					Regex:        "true",
+					SourceLabels: []string{"__meta_kubernetes_pod_labelpresent_app"},
+				},
+				{
+					Action:       "drop",
+					Regex:        "true",
+					SourceLabels: []string{"__meta_kubernetes_pod_labelpresent_canary"},
+				},
+				{
+					Action:       "drop",
+					Regex:        "dev",
+					SourceLabels: []string{"__meta_kubernetes_pod_label_env"},
+				},
+				{
+					Action:       "keep",
+					Regex:        "backend|frontend",
+					SourceLabels: []string{"__meta_kubernetes_pod_label_tier"},
+				},
+				{
+					Action:       "drop",
+					Regex:        "eu",
+					SourceLabels: []string{"__meta_kubernetes_pod_label_zone"},
+				},
+			},
+			expectedJobName: "default/deployment/web/nginx",
+		},
+		{
+			name: "case 2: label names sanitized and values quoted",
+			key: Key{
+				Namespace:     "default",
+				Workload:      "StatefulSet/db",
+				Labels:        "app.kubernetes.io/name in (db.primary,db.replica)",
+				ContainerName: "postgres",
+			},
+			expectedRequirements: []promtail.RelabelConfig{
+				{
+					Action:       "keep",
+					Regex:        `db\.primary|db\.replica`,
+					SourceLabels: []string{"__meta_kubernetes_pod_label_app_kubernetes_io_name"},
+				},
+			},
+			expectedJobName: "default/statefulset/db/postgres",
+		},
+		{
+			name: "case 3: key without workload",
+			key: Key{
+				Namespace:     "default",
+				Labels:        "app=web",
+				ContainerName: "nginx",
+			},
+			expectedRequirements: []promtail.RelabelConfig{
+				{
+					Action:       "keep",
+					Regex:        "web",
+					SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+				},
+			},
+			expectedJobName: "default/nginx/f95e1400",
+		},
+		{
+			name: "case 4: invalid label selector",
+			key: Key{
+				Namespace:     "default",
+				Labels:        "app in web",
+				ContainerName: "nginx",
+			},
+			errorMatcher: IsInvalidKey,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scrapeConfig, err := NewScrapeConfig(tc.key, nil)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+			if tc.errorMatcher != nil {
+				return
+			}
+
+			if scrapeConfig.JobName != tc.expectedJobName {
+				t.Fatalf("expected job name %#q, got %#q", tc.expectedJobName, scrapeConfig.JobName)
+			}
+			expectedSDConfigs := []promtail.KubernetesSDConfig{
+				{
+					Role: "pod",
+					Namespaces: &promtail.KubernetesNamespaces{
+						Names: []string{tc.key.Namespace},
+					},
+				},
+			}
+			if !reflect.DeepEqual(scrapeConfig.KubernetesSDConfigs, expectedSDConfigs) {
+				t.Fatalf("expected kubernetes_sd_configs %#v, got %#v", expectedSDConfigs, scrapeConfig.KubernetesSDConfigs)
+			}
+
+			relabelConfigs := scrapeConfig.RelabelConfigs
+			if len(relabelConfigs) != len(tc.expectedRequirements)+6 {
+				t.Fatalf("expected %d relabel configs, got %#v", len(tc.expectedRequirements)+6, relabelConfigs)
+			}
+			expectedNamespace := promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        tc.key.Namespace,
+				SourceLabels: []string{"__meta_kubernetes_namespace"},
+			}
+			if !reflect.DeepEqual(relabelConfigs[0], expectedNamespace) {
+				t.Fatalf("expected namespace relabel config %#v, got %#v", expectedNamespace, relabelConfigs[0])
+			}
+			requirements := relabelConfigs[1 : len(tc.expectedRequirements)+1]
+			if !reflect.DeepEqual(requirements, tc.expectedRequirements) {
+				t.Fatalf("expected requirement relabel configs %#v, got %#v", tc.expectedRequirements, requirements)
+			}
+			expectedContainer := promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        tc.key.ContainerName,
+				SourceLabels: []string{"__meta_kubernetes_pod_container_name"},
+			}
+			if !reflect.DeepEqual(relabelConfigs[len(tc.expectedRequirements)+1], expectedContainer) {
+				t.Fatalf("expected container relabel config %#v, got %#v", expectedContainer, relabelConfigs[len(tc.expectedRequirements)+1])
+			}
+		})
+	}
+}
+
+func Test_newRequirementRelabelConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		selector string
+		expected promtail.RelabelConfig
+	}{
+		{
+			name:     "case 0: in",
+			selector: "app in (web,api)",
+			expected: promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        "api|web",
+				SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+			},
+		},
+		{
+			name:     "case 1: not in",
+			selector: "app notin (web,api)",
+			expected: promtail.RelabelConfig{
+				Action:       "drop",
+				Regex:        "api|web",
+				SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+			},
+		},
+		{
+			name:     "case 2: exists",
+			selector: "app",
+			expected: promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        "true",
+				SourceLabels: []string{"__meta_kubernetes_pod_labelpresent_app"},
+			},
+		},
+		{
+			name:     "case 3: does not exist",
+			selector: "!app",
+			expected: promtail.RelabelConfig{
+				Action:       "drop",
+				Regex:        "true",
+				SourceLabels: []string{"__meta_kubernetes_pod_labelpresent_app"},
+			},
+		},
+		{
+			name:     "case 4: equals",
+			selector: "app=web",
+			expected: promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        "web",
+				SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+			},
+		},
+		{
+			name:     "case 5: not equals",
+			selector: "app!=web",
+			expected: promtail.RelabelConfig{
+				Action:       "drop",
+				Regex:        "web",
+				SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+			},
+		},
+		{
+			name:     "case 6: exists with a prefixed label name",
+			selector: "example.com/tier",
+			expected: promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        "true",
+				SourceLabels: []string{"__meta_kubernetes_pod_labelpresent_example_com_tier"},
+			},
+		},
+		{
+			name:     "case 7: in with values needing quotes",
+			selector: "version in (1.0,1.1)",
+			expected: promtail.RelabelConfig{
+				Action:       "keep",
+				Regex:        `1\.0|1\.1`,
+				SourceLabels: []string{"__meta_kubernetes_pod_label_version"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requirements, err := labels.ParseToRequirements(tc.selector)
+			if err != nil {
+				t.Fatalf("expected no error parsing the selector, got %#q", err)
+			}
+			if len(requirements) != 1 {
+				t.Fatalf("expected 1 requirement, got %d", len(requirements))
+			}
+
+			relabelConfig := newRequirementRelabelConfig(requirements[0])
+
+			if !reflect.DeepEqual(relabelConfig, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, relabelConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package promtailconfig
+
+import (
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+// Snippet is the part of promtail's config contributed for a Key. Pipeline
+// snippets hold just the pipeline stages of the Key's job, the rest of the
+// job is generated from the Key. Legacy snippets hold a list of complete
+// scrape configs, which are included as they are.
+type Snippet struct {
+	PipelineStages []promtail.PipelineStage
+	ScrapeConfigs  []promtail.ScrapeConfig
+}
+
+type pipelineSnippet struct {
+	PipelineStages []promtail.PipelineStage `json:"pipeline_stages"`
+}
+
+// ParseSnippet parses the YAML content of a snippet and validates its pipeline
+// stages. It returns an invalidSnippetError when the content is neither a
+// mapping holding the pipeline_stages of a job nor a list of scrape configs,
+// or when a stage is invalid.
+func ParseSnippet(content string) (*Snippet, error) {
+	var raw interface{}
+	err := yaml.Unmarshal([]byte(content), &raw)
+	if err != nil {
+		return nil, microerror.Maskf(invalidSnippetError, "snippet is not valid YAML: %v", err)
+	}
+
+	switch raw.(type) {
+	case map[string]interface{}:
+		var p pipelineSnippet
+		err = yaml.UnmarshalStrict([]byte(content), &p)
+		if err != nil {
+			return nil, microerror.Maskf(invalidSnippetError, "invalid pipeline snippet: %v", err)
+		}
+		if len(p.PipelineStages) == 0 {
+			return nil, microerror.Maskf(invalidSnippetError, "pipeline snippet must define pipeline_stages")
+		}
+		err = promtail.ValidatePipelineStages(p.PipelineStages)
+		if err != nil {
+			return nil, microerror.Maskf(invalidSnippetError, "%s", message(err))
+		}
+
+		return &Snippet{PipelineStages: p.PipelineStages}, nil
+	case []interface{}:
+		scrapeConfigs, err := promtail.UnmarshalScrapeConfigs([]byte(content))
+		if err != nil {
+			return nil, microerror.Maskf(invalidSnippetError, "invalid scrape configs: %s", message(err))
+		}
+		err = promtail.ValidateScrapeConfigs(scrapeConfigs)
+		if err != nil {
+			return nil, microerror.Maskf(invalidSnippetError, "%s", message(err))
+		}
+
+		return &Snippet{ScrapeConfigs: scrapeConfigs}, nil
+	}
+
+	return nil, microerror.Maskf(invalidSnippetError, "snippet must be a mapping holding pipeline_stages or a list of scrape configs")
+}
+
+// String returns the canonical YAML form of the snippet. Snippets differing
+// only in formatting have the same canonical form.
+func (s *Snippet) String() (string, error) {
+	var b []byte
+	var err error
+	if s.ScrapeConfigs != nil {
+		b, err = yaml.Marshal(s.ScrapeConfigs)
+	} else {
+		b, err = yaml.Marshal(pipelineSnippet{PipelineStages: s.PipelineStages})
+	}
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return string(b), nil
+}
+
+// Jobs returns the scrape configs to render for key.
+func (s *Snippet) Jobs(key Key) ([]promtail.ScrapeConfig, error) {
+	if s.ScrapeConfigs != nil {
+		return s.ScrapeConfigs, nil
+	}
+
+	scrapeConfig, err := NewScrapeConfig(key, s.PipelineStages)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return []promtail.ScrapeConfig{*scrapeConfig}, nil
+}
+
+// CanonicalSnippet parses content and returns its canonical form, which is the
+// form snippets are registered in.
+func CanonicalSnippet(content string) (string, error) {
+	snippet, err := ParseSnippet(content)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return snippet.String()
+}
+
+// message returns the message of err without the kinds of the errors it
+// masks, as these are of no use for the authors of snippets.
+func message(err error) string {
+	if e, ok := err.(interface{ Message() string }); ok && e.Message() != "" {
+		return e.Message()
+	}
+
+	return err.Error()
+}
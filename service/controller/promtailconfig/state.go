@@ -0,0 +1,112 @@
+package promtailconfig
+
+import (
+	"encoding/json"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	// StateKeyName is the key of promtail's ConfigMap holding the snippets
+	// rendered into promtail's config, keyed by their Keys. promtail's
+	// config is only ever written, the snippets are read back from here.
+	StateKeyName = "loki-operator-state.json"
+
+	// stateVersion is the version of the state format written. Bump it
+	// whenever the format changes and teach decodeState the former one.
+	stateVersion = 1
+)
+
+// state is the format of the StateKeyName key.
+type state struct {
+	Version  int          `json:"version"`
+	Snippets []stateEntry `json:"snippets"`
+}
+
+type stateEntry struct {
+	Key     stateKey `json:"key"`
+	Snippet string   `json:"snippet"`
+}
+
+type stateKey struct {
+	Namespace     string `json:"namespace"`
+	Workload      string `json:"workload,omitempty"`
+	Labels        string `json:"labels"`
+	ContainerName string `json:"container"`
+}
+
+// encodeState returns the state holding snippets. Entries are sorted by Key,
+// so the same snippets always encode the same state.
+func encodeState(snippets map[Key]string) (string, error) {
+	var keys []Key
+	for key := range snippets {
+		keys = append(keys, key)
+	}
+	SortKeys(keys)
+
+	s := state{
+		Version:  stateVersion,
+		Snippets: []stateEntry{},
+	}
+	for _, key := range keys {
+		s.Snippets = append(s.Snippets, stateEntry{
+			Key: stateKey{
+				Namespace:     key.Namespace,
+				Workload:      key.Workload,
+				Labels:        key.Labels,
+				ContainerName: key.ContainerName,
+			},
+			Snippet: snippets[key],
+		})
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return string(b) + "\n", nil
+}
+
+// decodeState returns the snippets held by the state. It returns an
+// invalidStateError when the state is malformed or of an unknown version.
+func decodeState(content string) (map[Key]string, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal([]byte(content), &header)
+	if err != nil {
+		return nil, microerror.Maskf(invalidStateError, "state is not valid JSON: %v", err)
+	}
+
+	switch header.Version {
+	case 1:
+		return decodeStateV1(content)
+	}
+
+	return nil, microerror.Maskf(invalidStateError, "state version %d is not supported", header.Version)
+}
+
+func decodeStateV1(content string) (map[Key]string, error) {
+	var s state
+	err := json.Unmarshal([]byte(content), &s)
+	if err != nil {
+		return nil, microerror.Maskf(invalidStateError, "state is not valid: %v", err)
+	}
+
+	snippets := make(map[Key]string, len(s.Snippets))
+	for _, e := range s.Snippets {
+		key := Key{
+			Namespace:     e.Key.Namespace,
+			Workload:      e.Key.Workload,
+			Labels:        e.Key.Labels,
+			ContainerName: e.Key.ContainerName,
+		}
+		if _, found := snippets[key]; found {
+			return nil, microerror.Maskf(invalidStateError, "state holds key %+v more than once", key)
+		}
+		snippets[key] = e.Snippet
+	}
+
+	return snippets, nil
+}
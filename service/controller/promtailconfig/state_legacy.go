@@ -0,0 +1,107 @@
+package promtailconfig
+
+import (
+	"strings"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+// decodeLegacyState recovers the snippets from promtail's config written
+// before the state key existed. The jobs of every Key are preceded by the
+// container, namespace and labels comments, optionally followed by the
+// workload comment. It returns an invalidStateError when the comments are
+// missing or out of order.
+func decodeLegacyState(config string) (map[Key]string, error) {
+	lines := strings.Split(config, "\n")
+
+	start := len(lines)
+	for i, line := range lines {
+		if line == scrapeConfigsKey {
+			start = i + 1
+			break
+		}
+	}
+
+	snippets := make(map[Key]string)
+	for start < len(lines) {
+		if strings.TrimSpace(lines[start]) == "" {
+			start++
+			continue
+		}
+		key, headerLines, err := parseLegacyKey(lines, start)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		start += headerLines
+
+		end := start
+		for end < len(lines) && !strings.HasPrefix(lines[end], containerHeader) {
+			end++
+		}
+		snippets[key] = parseJobs(key, strings.Join(lines[start:end], "\n"))
+		start = end
+	}
+
+	return snippets, nil
+}
+
+// parseLegacyKey parses the Key from the comments at start. It returns the
+// number of comment lines, as the workload comment is optional.
+func parseLegacyKey(lines []string, start int) (Key, int, error) {
+	if !(start+2 < len(lines) &&
+		strings.HasPrefix(lines[start], containerHeader+" ") &&
+		strings.HasPrefix(lines[start+1], nsHeader+" ") &&
+		strings.HasPrefix(lines[start+2], labelsHeader+" ")) {
+		return Key{}, 0, microerror.Maskf(invalidStateError, "expected the comments identifying a key at line %d", start+1)
+	}
+
+	key := Key{
+		ContainerName: lines[start][len(containerHeader)+1:],
+		Namespace:     lines[start+1][len(nsHeader)+1:],
+		Labels:        lines[start+2][len(labelsHeader)+1:],
+	}
+	if start+3 < len(lines) && strings.HasPrefix(lines[start+3], workloadHeader+" ") {
+		key.Workload = lines[start+3][len(workloadHeader)+1:]
+		return key, 4, nil
+	}
+
+	return key, 3, nil
+}
+
+// parseJobs recovers the canonical snippet from the jobs rendered for key. A
+// single job equal to the job generated for key comes from a pipeline
+// snippet. Jobs which can't be parsed are returned as they are.
+func parseJobs(key Key, rendered string) string {
+	jobs, err := promtail.UnmarshalScrapeConfigs([]byte(rendered))
+	if err != nil {
+		return rendered
+	}
+
+	snippet := &Snippet{ScrapeConfigs: jobs}
+	if len(jobs) == 1 {
+		generated, err := NewScrapeConfig(key, jobs[0].PipelineStages)
+		if err == nil && renderEqual(generated, &jobs[0]) {
+			snippet = &Snippet{PipelineStages: jobs[0].PipelineStages}
+		}
+	}
+
+	canonical, err := snippet.String()
+	if err != nil {
+		return rendered
+	}
+	return canonical
+}
+
+func renderEqual(a, b *promtail.ScrapeConfig) bool {
+	ra, err := a.Render()
+	if err != nil {
+		return false
+	}
+	rb, err := b.Render()
+	if err != nil {
+		return false
+	}
+	return ra == rb
+}
@@ -0,0 +1,307 @@
+package promtailconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/loki-operator/pkg/promtail"
+)
+
+var (
+	stateKeyWeb = Key{Namespace: "default", Workload: "Deployment/web", Labels: "app=web", ContainerName: "nginx"}
+	stateKeyAPI = Key{Namespace: "team", Labels: "app=api,tier in (backend)", ContainerName: "api"}
+)
+
+func Test_State_RoundTrip(t *testing.T) {
+	testCases := []struct {
+		name     string
+		snippets map[Key]string
+	}{
+		{
+			name:     "case 0: no snippets",
+			snippets: map[Key]string{},
+		},
+		{
+			name: "case 1: snippets of keys with and without workload",
+			snippets: map[Key]string{
+				stateKeyWeb: "pipeline_stages:\n- docker: {}\n",
+				stateKeyAPI: "- job_name: team/api\n  kubernetes_sd_configs:\n  - role: pod\n",
+			},
+		},
+		{
+			name: "case 2: snippet needing escapes",
+			snippets: map[Key]string{
+				stateKeyWeb: "pipeline_stages:\n- regex:\n    expression: '^\"(?P<msg>.*)\"\\t$'\n",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := encodeState(tc.snippets)
+			if err != nil {
+				t.Fatalf("expected no error encoding the state, got %#q", err)
+			}
+
+			snippets, err := decodeState(content)
+			if err != nil {
+				t.Fatalf("expected no error decoding the state, got %#q", err)
+			}
+			if !reflect.DeepEqual(snippets, tc.snippets) {
+				t.Fatalf("expected %#v, got %#v", tc.snippets, snippets)
+			}
+
+			again, err := encodeState(snippets)
+			if err != nil {
+				t.Fatalf("expected no error encoding the state again, got %#q", err)
+			}
+			if again != content {
+				t.Fatalf("expected the same snippets to encode the same state, got\n%s\nand\n%s", content, again)
+			}
+		})
+	}
+}
+
+func Test_encodeState(t *testing.T) {
+	content, err := encodeState(map[Key]string{
+		stateKeyAPI: "api",
+		stateKeyWeb: "web",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+
+	expected := `{
+  "version": 1,
+  "snippets": [
+    {
+      "key": {
+        "namespace": "default",
+        "workload": "Deployment/web",
+        "labels": "app=web",
+        "container": "nginx"
+      },
+      "snippet": "web"
+    },
+    {
+      "key": {
+        "namespace": "team",
+        "labels": "app=api,tier in (backend)",
+        "container": "api"
+      },
+      "snippet": "api"
+    }
+  ]
+}
+`
+	if content != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, content)
+	}
+}
+
+func Test_decodeState(t *testing.T) {
+	testCases := []struct {
+		name         string
+		content      string
+		expected     map[Key]string
+		errorMatcher func(error) bool
+	}{
+		{
+			name:     "case 0: empty state",
+			content:  `{"version": 1, "snippets": []}`,
+			expected: map[Key]string{},
+		},
+		{
+			name: "case 1: unknown fields ignored",
+			content: `{"version": 1, "generator": "test", "snippets": [
+				{"key": {"namespace": "default", "labels": "app=web", "container": "nginx", "extra": true}, "snippet": "web"}
+			]}`,
+			expected: map[Key]string{
+				{Namespace: "default", Labels: "app=web", ContainerName: "nginx"}: "web",
+			},
+		},
+		{
+			name:         "case 2: unknown version",
+			content:      `{"version": 2, "snippets": []}`,
+			errorMatcher: IsInvalidState,
+		},
+		{
+			name:         "case 3: version missing",
+			content:      `{"snippets": []}`,
+			errorMatcher: IsInvalidState,
+		},
+		{
+			name:         "case 4: not JSON",
+			content:      "version: 1\n",
+			errorMatcher: IsInvalidState,
+		},
+		{
+			name:         "case 5: snippets of the wrong type",
+			content:      `{"version": 1, "snippets": {}}`,
+			errorMatcher: IsInvalidState,
+		},
+		{
+			name: "case 6: key held twice",
+			content: `{"version": 1, "snippets": [
+				{"key": {"namespace": "default", "labels": "app=web", "container": "nginx"}, "snippet": "a"},
+				{"key": {"namespace": "default", "labels": "app=web", "container": "nginx"}, "snippet": "b"}
+			]}`,
+			errorMatcher: IsInvalidState,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snippets, err := decodeState(tc.content)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+
+			if !reflect.DeepEqual(snippets, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, snippets)
+			}
+		})
+	}
+}
+
+// legacyJobs renders the jobs of key the way promtail's config was rendered
+// before the state key existed: the Key's comments followed by the jobs.
+func legacyJobs(t *testing.T, key Key, jobs []promtail.ScrapeConfig) string {
+	t.Helper()
+
+	header := []string{
+		containerHeader + " " + key.ContainerName,
+		nsHeader + " " + key.Namespace,
+		labelsHeader + " " + key.Labels,
+	}
+	if key.Workload != "" {
+		header = append(header, workloadHeader+" "+key.Workload)
+	}
+
+	b, err := yaml.Marshal(jobs)
+	if err != nil {
+		t.Fatalf("expected no error rendering the jobs, got %#q", err)
+	}
+
+	return strings.Join(header, "\n") + "\n" + string(b)
+}
+
+func Test_decodeLegacyState(t *testing.T) {
+	stages := `
+- docker: {}
+- labels:
+    level:
+`
+	var pipelineStages []promtail.PipelineStage
+	err := yaml.Unmarshal([]byte(stages), &pipelineStages)
+	if err != nil {
+		t.Fatalf("expected no error parsing the stages, got %#q", err)
+	}
+	pipelineSnippet, err := CanonicalSnippet("pipeline_stages:" + stages)
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+
+	webJob, err := NewScrapeConfig(stateKeyWeb, pipelineStages)
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+	apiJob, err := NewScrapeConfig(stateKeyAPI, pipelineStages)
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+
+	customJobs := []promtail.ScrapeConfig{
+		{
+			JobName: "team/custom",
+			KubernetesSDConfigs: []promtail.KubernetesSDConfig{
+				{Role: "pod"},
+			},
+			PipelineStages: pipelineStages,
+		},
+	}
+	customSnippet, err := (&Snippet{ScrapeConfigs: customJobs}).String()
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+
+	head := "server:\n  http_listen_port: 3101\nscrape_configs:\n"
+
+	testCases := []struct {
+		name         string
+		config       string
+		expected     map[Key]string
+		errorMatcher func(error) bool
+	}{
+		{
+			name:     "case 0: config without scrape configs",
+			config:   "server:\n  http_listen_port: 3101\n",
+			expected: map[Key]string{},
+		},
+		{
+			name:   "case 1: generated job with workload comment",
+			config: head + legacyJobs(t, stateKeyWeb, []promtail.ScrapeConfig{*webJob}),
+			expected: map[Key]string{
+				stateKeyWeb: pipelineSnippet,
+			},
+		},
+		{
+			name:   "case 2: generated job without workload comment",
+			config: head + legacyJobs(t, stateKeyAPI, []promtail.ScrapeConfig{*apiJob}),
+			expected: map[Key]string{
+				stateKeyAPI: pipelineSnippet,
+			},
+		},
+		{
+			name: "case 3: generated and custom jobs separated by blank lines",
+			config: head + legacyJobs(t, stateKeyWeb, []promtail.ScrapeConfig{*webJob}) + "\n" +
+				legacyJobs(t, stateKeyAPI, customJobs),
+			expected: map[Key]string{
+				stateKeyWeb: pipelineSnippet,
+				stateKeyAPI: customSnippet,
+			},
+		},
+		{
+			name:         "case 4: jobs without comments",
+			config:       head + "- job_name: default/web\n",
+			errorMatcher: IsInvalidState,
+		},
+		{
+			name:         "case 5: comments out of order",
+			config:       head + nsHeader + " default\n" + containerHeader + " nginx\n" + labelsHeader + " app=web\n- job_name: default/web\n",
+			errorMatcher: IsInvalidState,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snippets, err := decodeLegacyState(tc.config)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+
+			if !reflect.DeepEqual(snippets, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, snippets)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package promtailconfig
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// syncQueueItem is the only item ever put into the queue. The queue
+	// deduplicates it, so every burst of changes results in a single render.
+	syncQueueItem = "promtail-config"
+)
+
+// Reloader makes promtail pick up its changed config.
+type Reloader interface {
+	// Reload schedules a reload of promtail. It must not block.
+	Reload()
+}
+
+// RenderObserver learns which snippets got rendered into promtail's ConfigMap.
+type RenderObserver interface {
+	// Rendered is called after every successful update of promtail's
+	// ConfigMap with the registrations whose snippets it holds. It must not
+	// block.
+	Rendered(registrations []Registration)
+}
+
+// configWriter is the part of PromtailConfigMap used by SyncHandler.
+type configWriter interface {
+	Accept(key Key, content string) (string, error)
+	Update(newSnippets map[Key]string) (bool, error)
+}
+
+type SyncHandlerConfig struct {
+	Logger            micrologger.Logger
+	PromtailConfigMap *PromtailConfigMap
+	// Reloader is told about every write to promtail's ConfigMap. It is
+	// optional.
+	Reloader Reloader
+	// RenderObservers are told about the rendered snippets after every
+	// update of promtail's ConfigMap. They are optional.
+	RenderObservers []RenderObserver
+
+	// MaxWait is the longest time a change waits to be written, even when
+	// changes keep coming in without a quiet period.
+	MaxWait time.Duration
+	// QuietPeriod is the time without any changes after which the changes
+	// are written to promtail's ConfigMap.
+	QuietPeriod time.Duration
+}
+
+// SyncHandler is an implementation of Handler, that writes promtail's configmap
+// whenever snippets change. Bursts of AddConfig/DelConfig calls are coalesced
+// into a single write, once no change happened for the quiet period or the
+// first pending change waited for the max wait time. Failed writes are
+// retried with backoff.
+type SyncHandler struct {
+	logger    micrologger.Logger
+	promMap   configWriter
+	queue     workqueue.RateLimitingInterface
+	registry  *Registry
+	reloader  Reloader
+	observers []RenderObserver
+
+	// mutex guards lastChange and pendingSince.
+	mutex sync.Mutex
+	// lastChange is the time of the latest change.
+	lastChange time.Time
+	// pendingSince is the time of the first change not written yet. It is
+	// zero when there are no pending changes.
+	pendingSince time.Time
+
+	// synced is closed by the first call to Synced.
+	synced     chan struct{}
+	syncedOnce sync.Once
+
+	maxWait     time.Duration
+	quietPeriod time.Duration
+}
+
+func NewSyncHandler(config SyncHandlerConfig) (*SyncHandler, error) {
+	if config.PromtailConfigMap == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PromtailConfigMap must not be empty", config)
+	}
+
+	return newSyncHandler(config, config.PromtailConfigMap)
+}
+
+// newSyncHandler allows tests to replace promtail's ConfigMap with writer.
+func newSyncHandler(config SyncHandlerConfig, writer configWriter) (*SyncHandler, error) {
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.QuietPeriod <= 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.QuietPeriod must be greater than 0", config)
+	}
+	if config.MaxWait < config.QuietPeriod {
+		return nil, microerror.Maskf(invalidConfigError, "%T.MaxWait must not be less than %T.QuietPeriod", config, config)
+	}
+
+	s := &SyncHandler{
+		logger:    config.Logger,
+		promMap:   writer,
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), syncQueueItem),
+		registry:  NewRegistry(),
+		reloader:  config.Reloader,
+		observers: config.RenderObservers,
+
+		synced: make(chan struct{}),
+
+		maxWait:     config.MaxWait,
+		quietPeriod: config.QuietPeriod,
+	}
+
+	return s, nil
+}
+
+// AddConfig registers the canonical form of yamlContent. Snippets which can't
+// be parsed or rendered for key, or violate the policy, are rejected, the
+// snippet registered before for key by source is kept then.
+func (s *SyncHandler) AddConfig(key Key, source types.UID, yamlContent string) error {
+	canonical, err := s.promMap.Accept(key, yamlContent)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = s.registry.Register(key, source, canonical)
+	s.changed()
+	return err
+}
+
+func (s *SyncHandler) DelConfig(key Key, source types.UID) {
+	s.registry.Unregister(key, source)
+	s.changed()
+}
+
+func (s *SyncHandler) DelSource(source types.UID) {
+	s.registry.UnregisterSource(source)
+	s.changed()
+}
+
+func (s *SyncHandler) RetainConfigs(source types.UID, keys []Key) {
+	s.registry.RetainSource(source, keys)
+	s.changed()
+}
+
+func (s *SyncHandler) Registrations() []Registration {
+	return s.registry.Registrations()
+}
+
+func (s *SyncHandler) Synced() {
+	s.syncedOnce.Do(func() {
+		close(s.synced)
+	})
+	s.changed()
+}
+
+func (s *SyncHandler) Refresh() {
+	s.changed()
+}
+
+// Boot waits until the handler is synced and then writes pending changes
+// until ctx is canceled. Changes made before are queued and written once the
+// handler is synced.
+func (s *SyncHandler) Boot(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.queue.ShutDown()
+	}()
+
+	select {
+	case <-s.synced:
+	case <-ctx.Done():
+		return
+	}
+
+	for s.processNextItem(ctx) {
+	}
+}
+
+// changed records a change and schedules a write after the quiet period.
+func (s *SyncHandler) changed() {
+	s.mutex.Lock()
+	now := time.Now()
+	if s.pendingSince.IsZero() {
+		s.pendingSince = now
+	}
+	s.lastChange = now
+	s.mutex.Unlock()
+
+	s.queue.AddAfter(syncQueueItem, s.quietPeriod)
+}
+
+func (s *SyncHandler) processNextItem(ctx context.Context) bool {
+	item, shutdown := s.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.queue.Done(item)
+
+	wait := s.remainingWait(time.Now())
+	if wait > 0 {
+		// Changes came in after the write was scheduled, wait for them to
+		// settle.
+		s.queue.AddAfter(item, wait)
+		return true
+	}
+
+	// Changes made from here on mark the item dirty in the queue, so they
+	// are written in the next round.
+	s.mutex.Lock()
+	pendingSince := s.pendingSince
+	s.pendingSince = time.Time{}
+	s.mutex.Unlock()
+
+	snippets := s.registry.Snippets()
+	written, err := s.promMap.Update(snippets)
+	if IsBaseConfigMissing(err) {
+		// Retrying doesn't help, the write is scheduled again by Refresh
+		// once the base config is loaded.
+		s.logger.LogCtx(ctx, "level", "debug", "message", "waiting for the base config to update promtail config map")
+		s.restorePending(pendingSince)
+		s.queue.Forget(item)
+		return true
+	} else if err != nil {
+		s.logger.LogCtx(ctx, "level", "error", "message", "failed to update promtail config map", "stack", microerror.Stack(err))
+		s.restorePending(pendingSince)
+
+		s.queue.AddRateLimited(item)
+		return true
+	}
+
+	if written && s.reloader != nil {
+		s.reloader.Reload()
+	}
+	if len(s.observers) > 0 {
+		rendered := s.renderedRegistrations(snippets)
+		for _, o := range s.observers {
+			o.Rendered(rendered)
+		}
+	}
+
+	s.queue.Forget(item)
+	return true
+}
+
+// restorePending marks the changes pending since pendingSince as not written
+// yet.
+func (s *SyncHandler) restorePending(pendingSince time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pendingSince.IsZero() || pendingSince.Before(s.pendingSince) {
+		s.pendingSince = pendingSince
+	}
+}
+
+// remainingWait returns how much longer pending changes have to wait before
+// being written.
+func (s *SyncHandler) remainingWait(now time.Time) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pendingSince.IsZero() {
+		return 0
+	}
+
+	end := s.lastChange.Add(s.quietPeriod)
+	if maxEnd := s.pendingSince.Add(s.maxWait); maxEnd.Before(end) {
+		end = maxEnd
+	}
+
+	return end.Sub(now)
+}
+
+// renderedRegistrations returns the current registrations whose snippets are
+// among the rendered snippets. Registrations of sources losing a conflict are
+// left out.
+func (s *SyncHandler) renderedRegistrations(snippets map[Key]string) []Registration {
+	var rendered []Registration
+	for _, reg := range s.registry.Registrations() {
+		snippet, found := snippets[reg.Key]
+		if found && snippet == reg.Snippet {
+			rendered = append(rendered, reg)
+		}
+	}
+
+	return rendered
+}
@@ -0,0 +1,201 @@
+package promtailconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/micrologger/microloggertest"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeConfigWriter accepts every snippet as it is and reports every update on
+// its channel.
+type fakeConfigWriter struct {
+	updates chan configUpdate
+}
+
+type configUpdate struct {
+	at       time.Time
+	snippets map[Key]string
+}
+
+func newFakeConfigWriter() *fakeConfigWriter {
+	return &fakeConfigWriter{
+		updates: make(chan configUpdate, 100),
+	}
+}
+
+func (w *fakeConfigWriter) Accept(key Key, content string) (string, error) {
+	return content, nil
+}
+
+func (w *fakeConfigWriter) Update(newSnippets map[Key]string) (bool, error) {
+	w.updates <- configUpdate{at: time.Now(), snippets: newSnippets}
+	return true, nil
+}
+
+// expectUpdate returns the next update, failing the test if none comes in
+// within timeout.
+func (w *fakeConfigWriter) expectUpdate(t *testing.T, timeout time.Duration) configUpdate {
+	t.Helper()
+
+	select {
+	case u := <-w.updates:
+		return u
+	case <-time.After(timeout):
+		t.Fatalf("expected an update within %v, got none", timeout)
+	}
+
+	return configUpdate{}
+}
+
+// expectNoUpdate fails the test if an update comes in within d.
+func (w *fakeConfigWriter) expectNoUpdate(t *testing.T, d time.Duration) {
+	t.Helper()
+
+	select {
+	case u := <-w.updates:
+		t.Fatalf("expected no update within %v, got %#v", d, u.snippets)
+	case <-time.After(d):
+	}
+}
+
+func bootSyncHandler(t *testing.T, quietPeriod, maxWait time.Duration) (*SyncHandler, *fakeConfigWriter, context.CancelFunc) {
+	t.Helper()
+
+	writer := newFakeConfigWriter()
+	c := SyncHandlerConfig{
+		Logger: microloggertest.New(),
+
+		MaxWait:     maxWait,
+		QuietPeriod: quietPeriod,
+	}
+	s, err := newSyncHandler(c, writer)
+	if err != nil {
+		t.Fatalf("expected no error creating the handler, got %#q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Boot(ctx)
+
+	return s, writer, cancel
+}
+
+func syncHandlerKey(i int) Key {
+	return Key{Namespace: "default", Labels: fmt.Sprintf("app=app-%d", i), ContainerName: "app"}
+}
+
+func Test_SyncHandler_Boot_WaitsForSynced(t *testing.T) {
+	s, writer, cancel := bootSyncHandler(t, 10*time.Millisecond, 50*time.Millisecond)
+	defer cancel()
+
+	err := s.AddConfig(syncHandlerKey(0), types.UID("pod-0"), "snippet")
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+
+	writer.expectNoUpdate(t, 200*time.Millisecond)
+
+	s.Synced()
+
+	u := writer.expectUpdate(t, time.Second)
+	if u.snippets[syncHandlerKey(0)] != "snippet" {
+		t.Fatalf("expected the snippet registered before the handler got synced, got %#v", u.snippets)
+	}
+}
+
+func Test_SyncHandler_Boot_Canceled(t *testing.T) {
+	writer := newFakeConfigWriter()
+	c := SyncHandlerConfig{
+		Logger: microloggertest.New(),
+
+		MaxWait:     50 * time.Millisecond,
+		QuietPeriod: 10 * time.Millisecond,
+	}
+	s, err := newSyncHandler(c, writer)
+	if err != nil {
+		t.Fatalf("expected no error creating the handler, got %#q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Boot(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Boot to return once canceled while waiting for the handler to get synced")
+	}
+	writer.expectNoUpdate(t, 50*time.Millisecond)
+}
+
+func Test_SyncHandler_Debounce(t *testing.T) {
+	quietPeriod := 100 * time.Millisecond
+	s, writer, cancel := bootSyncHandler(t, quietPeriod, 5*time.Second)
+	defer cancel()
+
+	s.Synced()
+	writer.expectUpdate(t, time.Second)
+
+	// A burst of changes, each coming in before the quiet period of the
+	// one before ends.
+	var lastChange time.Time
+	for i := 0; i < 5; i++ {
+		err := s.AddConfig(syncHandlerKey(i), types.UID(fmt.Sprintf("pod-%d", i)), "snippet")
+		if err != nil {
+			t.Fatalf("expected no error, got %#q", err)
+		}
+		lastChange = time.Now()
+		time.Sleep(quietPeriod / 5)
+	}
+
+	u := writer.expectUpdate(t, time.Second)
+	if len(u.snippets) != 5 {
+		t.Fatalf("expected the burst to be written at once, got %#v", u.snippets)
+	}
+	if u.at.Before(lastChange.Add(quietPeriod)) {
+		t.Fatalf("expected the write to wait for the quiet period after the last change, it came %v after it", u.at.Sub(lastChange))
+	}
+
+	writer.expectNoUpdate(t, 3*quietPeriod)
+}
+
+func Test_SyncHandler_MaxWait(t *testing.T) {
+	quietPeriod := 100 * time.Millisecond
+	maxWait := 300 * time.Millisecond
+	s, writer, cancel := bootSyncHandler(t, quietPeriod, maxWait)
+	defer cancel()
+
+	s.Synced()
+	writer.expectUpdate(t, time.Second)
+
+	// Changes keep coming in without a quiet period, only the max wait
+	// time gets them written.
+	start := time.Now()
+	for i := 0; time.Since(start) < 4*maxWait; i++ {
+		err := s.AddConfig(syncHandlerKey(i), types.UID(fmt.Sprintf("pod-%d", i)), "snippet")
+		if err != nil {
+			t.Fatalf("expected no error, got %#q", err)
+		}
+		time.Sleep(quietPeriod / 4)
+	}
+
+	var written int
+	for len(writer.updates) > 0 {
+		u := <-writer.updates
+		if u.at.Sub(start) < maxWait {
+			t.Fatalf("expected no write before the max wait time, got one after %v", u.at.Sub(start))
+		}
+		written++
+	}
+	if written < 2 {
+		t.Fatalf("expected at least 2 writes while changes kept coming in, got %d", written)
+	}
+}
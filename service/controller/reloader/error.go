@@ -0,0 +1,23 @@
+package reloader
+
+import (
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
+
+var reloadFailedError = &microerror.Error{
+	Kind: "reloadFailedError",
+}
+
+// IsReloadFailed asserts reloadFailedError.
+func IsReloadFailed(err error) bool {
+	return microerror.Cause(err) == reloadFailedError
+}
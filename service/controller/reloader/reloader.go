@@ -0,0 +1,354 @@
+// Package reloader makes promtail pick up its config after it was changed by
+// the operator, either by rolling out promtail's DaemonSet or by calling the
+// reload endpoint of every promtail pod.
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+const (
+	// ModeNone leaves it to promtail to pick up its config.
+	ModeNone = "none"
+	// ModeRollout patches the checksum of the config into the pod template
+	// of promtail's DaemonSet, which rolls out its pods.
+	ModeRollout = "rollout"
+	// ModeReload calls the reload endpoint of every promtail pod. promtail
+	// must run with runtime reload enabled.
+	ModeReload = "reload"
+
+	// ChecksumAnnotation is the annotation of the pod template of promtail's
+	// DaemonSet holding the checksum of the config. It is the annotation
+	// holding the checksum on promtail's ConfigMap.
+	ChecksumAnnotation = promtailconfig.ChecksumAnnotation
+
+	// reloadQueueItem is the only item ever put into the queue.
+	reloadQueueItem = "promtail-reload"
+	// propagationDelay is the time the kubelet may take to update the
+	// config file of promtail pods after the ConfigMap was written. Reload
+	// requests are delayed by it, so the pods don't reload the old config.
+	propagationDelay = 90 * time.Second
+	// requestTimeout is the timeout of a single reload request.
+	requestTimeout = 10 * time.Second
+)
+
+type Config struct {
+	K8sClient         kubernetes.Interface
+	Logger            micrologger.Logger
+	PromtailConfigMap *promtailconfig.PromtailConfigMap
+
+	// Mode is one of ModeNone, ModeRollout and ModeReload.
+	Mode string
+	// DaemonSetNamespace and DaemonSetName identify promtail's DaemonSet.
+	DaemonSetNamespace string
+	DaemonSetName      string
+	// HTTPPort is the port of promtail's HTTP server, used in ModeReload.
+	HTTPPort int
+	// MinInterval is the minimum time between two restarts or reloads of
+	// promtail.
+	MinInterval time.Duration
+}
+
+// Reloader is an implementation of promtailconfig.Reloader. Reloads requested
+// within MinInterval of the last one are coalesced into a single reload once
+// the interval passed, so frequent application changes can't keep promtail
+// restarting. Failed reloads are retried with backoff.
+type Reloader struct {
+	k8sClient         kubernetes.Interface
+	logger            micrologger.Logger
+	promtailConfigMap *promtailconfig.PromtailConfigMap
+	httpClient        *http.Client
+	queue             workqueue.RateLimitingInterface
+
+	mode               string
+	daemonSetNamespace string
+	daemonSetName      string
+	httpPort           int
+	minInterval        time.Duration
+
+	// mutex guards lastRequest.
+	mutex sync.Mutex
+	// lastRequest is the time of the latest reload request.
+	lastRequest time.Time
+	// lastReload is only accessed by the single worker.
+	lastReload time.Time
+}
+
+func New(config Config) (*Reloader, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.PromtailConfigMap == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PromtailConfigMap must not be empty", config)
+	}
+
+	switch config.Mode {
+	case ModeNone:
+	case ModeRollout, ModeReload:
+		if config.DaemonSetNamespace == "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.DaemonSetNamespace must not be empty", config)
+		}
+		if config.DaemonSetName == "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.DaemonSetName must not be empty", config)
+		}
+	default:
+		return nil, microerror.Maskf(invalidConfigError, "%T.Mode must be one of %#q, %#q or %#q, got %#q", config,
+			ModeNone, ModeRollout, ModeReload, config.Mode)
+	}
+	if config.Mode == ModeReload && config.HTTPPort <= 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.HTTPPort must be greater than 0", config)
+	}
+	if config.MinInterval < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.MinInterval must not be negative", config)
+	}
+
+	r := &Reloader{
+		k8sClient:         config.K8sClient,
+		logger:            config.Logger,
+		promtailConfigMap: config.PromtailConfigMap,
+		httpClient: &http.Client{
+			Timeout: requestTimeout,
+		},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), reloadQueueItem),
+
+		mode:               config.Mode,
+		daemonSetNamespace: config.DaemonSetNamespace,
+		daemonSetName:      config.DaemonSetName,
+		httpPort:           config.HTTPPort,
+		minInterval:        config.MinInterval,
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) Reload() {
+	if r.mode == ModeNone {
+		return
+	}
+
+	r.mutex.Lock()
+	r.lastRequest = time.Now()
+	r.mutex.Unlock()
+
+	r.queue.AddAfter(reloadQueueItem, r.requestDelay())
+}
+
+// Boot reloads promtail whenever requested until ctx is canceled. In
+// ModeRollout, promtail is rolled out right away when its DaemonSet wasn't
+// rolled out with the current config, e.g. because the operator stopped
+// between writing promtail's ConfigMap and rolling out the DaemonSet.
+func (r *Reloader) Boot(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		r.queue.ShutDown()
+	}()
+
+	if r.mode == ModeRollout {
+		outdated, err := r.rolloutOutdated()
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to compare the checksum of promtail daemonset with promtail config map",
+				"stack", microerror.Stack(err))
+			r.Reload()
+		} else if outdated {
+			r.logger.LogCtx(ctx, "level", "info", "message", "promtail daemonset was not rolled out with the current config")
+			r.Reload()
+		}
+	}
+
+	for r.processNextItem(ctx) {
+	}
+}
+
+func (r *Reloader) processNextItem(ctx context.Context) bool {
+	item, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(item)
+
+	now := time.Now()
+	if wait := r.remainingWait(now); wait > 0 {
+		r.logger.LogCtx(ctx, "level", "debug", "message", fmt.Sprintf("delaying reload of promtail by %s", wait))
+		r.queue.AddAfter(item, wait)
+		return true
+	}
+
+	var err error
+	switch r.mode {
+	case ModeRollout:
+		err = r.rollout(ctx)
+	case ModeReload:
+		err = r.reload(ctx)
+	}
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to reload promtail", "stack", microerror.Stack(err))
+		r.queue.AddRateLimited(item)
+		return true
+	}
+
+	r.lastReload = now
+	r.queue.Forget(item)
+	return true
+}
+
+// requestDelay returns the time a reload request waits at least.
+func (r *Reloader) requestDelay() time.Duration {
+	if r.mode == ModeReload {
+		return propagationDelay
+	}
+	return 0
+}
+
+// remainingWait returns how much longer a requested reload has to wait, so it
+// keeps the minimum interval and, in ModeReload, the config of the latest
+// request has reached the pods.
+func (r *Reloader) remainingWait(now time.Time) time.Duration {
+	r.mutex.Lock()
+	lastRequest := r.lastRequest
+	r.mutex.Unlock()
+
+	end := r.lastReload.Add(r.minInterval)
+	if requestEnd := lastRequest.Add(r.requestDelay()); requestEnd.After(end) {
+		end = requestEnd
+	}
+
+	return end.Sub(now)
+}
+
+// rolloutOutdated returns whether the checksum in the pod template of
+// promtail's DaemonSet differs from the checksum of the config held by
+// promtail's ConfigMap. Nothing needs to be rolled out before promtail's
+// ConfigMap is created.
+func (r *Reloader) rolloutOutdated() (bool, error) {
+	checksum, err := r.promtailConfigMap.Checksum()
+	if errors.IsNotFound(microerror.Cause(err)) {
+		return false, nil
+	} else if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	ds, err := r.k8sClient.AppsV1().DaemonSets(r.daemonSetNamespace).Get(r.daemonSetName, metav1.GetOptions{})
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	return ds.Spec.Template.Annotations[ChecksumAnnotation] != checksum, nil
+}
+
+// rollout patches the checksum of the current config into the pod template of
+// promtail's DaemonSet. The DaemonSet controller then replaces the pods
+// according to the DaemonSet's update strategy.
+func (r *Reloader) rollout(ctx context.Context) error {
+	checksum, err := r.promtailConfigMap.Checksum()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						ChecksumAnnotation: checksum,
+					},
+				},
+			},
+		},
+	}
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	_, err = r.k8sClient.AppsV1().DaemonSets(r.daemonSetNamespace).Patch(r.daemonSetName, types.StrategicMergePatchType, b)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	r.logger.LogCtx(ctx, "level", "info", "message", fmt.Sprintf("rolling out promtail daemonset %s/%s",
+		r.daemonSetNamespace, r.daemonSetName), "checksum", checksum)
+
+	return nil
+}
+
+// reload calls the reload endpoint of all running pods of promtail's
+// DaemonSet. All pods are called even if some of them fail.
+func (r *Reloader) reload(ctx context.Context) error {
+	ds, err := r.k8sClient.AppsV1().DaemonSets(r.daemonSetNamespace).Get(r.daemonSetName, metav1.GetOptions{})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	pods, err := r.k8sClient.CoreV1().Pods(r.daemonSetNamespace).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	reloaded := 0
+	var failed []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+
+		err = r.reloadPod(ctx, pod.Status.PodIP)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "warning", "message", fmt.Sprintf("failed to reload promtail pod %s", pod.Name),
+				"reason", err.Error())
+			failed = append(failed, pod.Name)
+			continue
+		}
+		reloaded++
+	}
+	if len(failed) > 0 {
+		return microerror.Maskf(reloadFailedError, "promtail pods %v", failed)
+	}
+
+	r.logger.LogCtx(ctx, "level", "info", "message", fmt.Sprintf("reloaded %d promtail pods", reloaded))
+
+	return nil
+}
+
+func (r *Reloader) reloadPod(ctx context.Context, podIP string) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s:%d/reload", podIP, r.httpPort), nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	resp, err := r.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return microerror.Maskf(reloadFailedError, "unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package promtailconfigcr
+
+import (
+	"context"
+
+	"github.com/giantswarm/microerror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+type pipelineSnippet struct {
+	PipelineStages []v1alpha1.PipelineStage `json:"pipeline_stages"`
+}
+
+func (r *Resource) EnsureCreated(ctx context.Context, obj interface{}) error {
+	cr, castOk := obj.(*v1alpha1.PromtailConfig)
+	if !castOk {
+		return nil
+	}
+
+	status := v1alpha1.PromtailConfigStatus{
+		RenderedGeneration: cr.Status.RenderedGeneration,
+	}
+
+	// PromtailConfigs of excluded namespaces are registered by the resync
+	// once their namespace gets included.
+	err := r.scope.Check(cr.Namespace)
+	if namespacescope.IsExcludedNamespace(err) {
+		r.logger.LogCtx(ctx, "level", "debug", "message", "ignoring PromtailConfig", "reason", err.Error())
+		r.unregister(cr.UID)
+
+		status.Accepted = false
+		status.Reason = err.Error()
+
+		return r.updateStatus(ctx, cr, status)
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	snippet, key, err := Snippet(cr)
+	if IsInvalidSpec(err) {
+		r.logger.LogCtx(ctx, "level", "warning", "message", "PromtailConfig rejected", "reason", err.Error())
+		r.unregister(cr.UID)
+
+		status.Accepted = false
+		status.Reason = err.Error()
+	} else if err != nil {
+		return microerror.Mask(err)
+	} else {
+		status.Accepted = true
+
+		err = r.register(cr, *key, snippet)
+		if promtailconfig.IsInvalidSnippet(err) {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "PromtailConfig rejected", "reason", err.Error())
+			r.unregister(cr.UID)
+
+			status = v1alpha1.PromtailConfigStatus{
+				RenderedGeneration: cr.Status.RenderedGeneration,
+				Reason:             err.Error(),
+			}
+		} else if promtailconfig.IsSnippetConflict(err) {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "PromtailConfig selects the same pods as another config",
+				"reason", err.Error())
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	if status.Accepted {
+		pods, err := r.k8sClient.K8sClient().CoreV1().Pods(cr.Namespace).List(metav1.ListOptions{LabelSelector: key.Labels})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		status.MatchedPods = len(pods.Items)
+	}
+
+	return r.updateStatus(ctx, cr, status)
+}
+
+func (r *Resource) updateStatus(ctx context.Context, cr *v1alpha1.PromtailConfig, status v1alpha1.PromtailConfigStatus) error {
+	if cr.Status == status {
+		return nil
+	}
+
+	r.logger.LogCtx(ctx, "level", "debug", "message", "updating PromtailConfig status")
+
+	cr.Status = status
+	err := r.k8sClient.CtrlClient().Status().Update(ctx, cr)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	r.logger.LogCtx(ctx, "level", "debug", "message", "updated PromtailConfig status")
+
+	return nil
+}
+
+// Snippet renders the pipeline stages of the PromtailConfig and the Key
+// selecting its pods and container. The filter part of the job is generated
+// when the snippet is rendered into promtail's ConfigMap. It returns an error
+// matched by IsInvalidSpec if the PromtailConfig can't be accepted.
+func Snippet(cr *v1alpha1.PromtailConfig) (string, *promtailconfig.Key, error) {
+	err := validateSpec(cr.Spec)
+	if err != nil {
+		return "", nil, microerror.Mask(err)
+	}
+	selector, err := podSelector(cr)
+	if err != nil {
+		return "", nil, microerror.Mask(err)
+	}
+
+	key := &promtailconfig.Key{
+		Namespace:     cr.Namespace,
+		Labels:        selector.String(),
+		ContainerName: cr.Spec.ContainerName,
+	}
+
+	snippet := pipelineSnippet{
+		PipelineStages: cr.Spec.PipelineStages,
+	}
+	b, err := yaml.Marshal(snippet)
+	if err != nil {
+		return "", nil, microerror.Mask(err)
+	}
+
+	return string(b), key, nil
+}
@@ -0,0 +1,16 @@
+package promtailconfigcr
+
+import (
+	"context"
+
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
+)
+
+func (r *Resource) EnsureDeleted(ctx context.Context, obj interface{}) error {
+	cr, castOk := obj.(*v1alpha1.PromtailConfig)
+	if !castOk {
+		return nil
+	}
+	r.unregister(cr.UID)
+	return nil
+}
@@ -0,0 +1,23 @@
+package promtailconfigcr
+
+import (
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
+
+var invalidSpecError = &microerror.Error{
+	Kind: "invalidSpecError",
+}
+
+// IsInvalidSpec asserts invalidSpecError.
+func IsInvalidSpec(err error) bool {
+	return microerror.Cause(err) == invalidSpecError
+}
@@ -0,0 +1,142 @@
+package promtailconfigcr
+
+import (
+	"fmt"
+
+	"github.com/giantswarm/k8sclient"
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+const (
+	Name = "promtailconfigcr"
+)
+
+type Config struct {
+	K8sClient k8sclient.Interface
+	Logger    micrologger.Logger
+	Handler   promtailconfig.Handler
+	Scope     *namespacescope.Scope
+	Tracker   *Tracker
+}
+
+type Resource struct {
+	k8sClient k8sclient.Interface
+	logger    micrologger.Logger
+	handler   promtailconfig.Handler
+	scope     *namespacescope.Scope
+	tracker   *Tracker
+}
+
+func New(config Config) (*Resource, error) {
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Handler == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Handler must not be empty", config)
+	}
+	if config.Scope == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Scope must not be empty", config)
+	}
+	if config.Tracker == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Tracker must not be empty", config)
+	}
+
+	r := &Resource{
+		logger:    config.Logger,
+		k8sClient: config.K8sClient,
+		handler:   config.Handler,
+		scope:     config.Scope,
+		tracker:   config.Tracker,
+	}
+
+	return r, nil
+}
+
+func (r *Resource) Name() string {
+	return Name
+}
+
+// register hands the snippet of cr over to the handler and retracts the
+// snippet cr was registered with before, if its Key changed. The tracker
+// learns the canonical snippet, to report the generation of cr once it got
+// rendered.
+func (r *Resource) register(cr *v1alpha1.PromtailConfig, key promtailconfig.Key, snippet string) error {
+	err := r.handler.AddConfig(key, cr.UID, snippet)
+	if err != nil && !promtailconfig.IsSnippetConflict(err) {
+		return microerror.Mask(err)
+	}
+	r.handler.RetainConfigs(cr.UID, []promtailconfig.Key{key})
+
+	for _, reg := range r.handler.Registrations() {
+		if reg.Source == cr.UID && reg.Key == key {
+			r.tracker.Watch(cr, key, reg.Snippet)
+		}
+	}
+
+	return err
+}
+
+// unregister retracts the snippets of the PromtailConfig identified by uid.
+func (r *Resource) unregister(uid types.UID) {
+	r.handler.DelSource(uid)
+	r.tracker.Forget(uid)
+}
+
+func podSelector(cr *v1alpha1.PromtailConfig) (labels.Selector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&cr.Spec.PodSelector)
+	if err != nil {
+		return nil, microerror.Maskf(invalidSpecError, "invalid pod selector: %v", err)
+	}
+	if selector.Empty() {
+		return nil, microerror.Maskf(invalidSpecError, "pod selector must not be empty")
+	}
+
+	return selector, nil
+}
+
+func validateSpec(spec v1alpha1.PromtailConfigSpec) error {
+	if spec.ContainerName == "" {
+		return microerror.Maskf(invalidSpecError, "container name must not be empty")
+	}
+	if len(spec.PipelineStages) == 0 {
+		return microerror.Maskf(invalidSpecError, "pipeline stages must not be empty")
+	}
+
+	return validateStages(spec.PipelineStages, "pipeline stage")
+}
+
+func validateStages(stages []v1alpha1.PipelineStage, path string) error {
+	for i, s := range stages {
+		stagePath := fmt.Sprintf("%s %d", path, i+1)
+
+		set := 0
+		for _, isSet := range []bool{s.JSON != nil, s.Labels != nil, s.Match != nil, s.Output != nil, s.Regex != nil, s.Template != nil, s.Timestamp != nil} {
+			if isSet {
+				set++
+			}
+		}
+		if set != 1 {
+			return microerror.Maskf(invalidSpecError, "%s must define exactly one stage type, found %d", stagePath, set)
+		}
+
+		if s.Match != nil {
+			err := validateStages(s.Match.Stages, stagePath+" of match")
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+	}
+
+	return nil
+}
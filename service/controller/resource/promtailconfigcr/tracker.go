@@ -0,0 +1,165 @@
+package promtailconfigcr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/giantswarm/k8sclient"
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+type TrackerConfig struct {
+	K8sClient k8sclient.Interface
+	Logger    micrologger.Logger
+}
+
+// Tracker sets the RenderedGeneration in the status of PromtailConfigs once
+// the snippet of their generation got rendered into promtail's ConfigMap.
+// Tracker implements promtailconfig.RenderObserver.
+type Tracker struct {
+	k8sClient k8sclient.Interface
+	logger    micrologger.Logger
+
+	// mutex guards configs.
+	mutex sync.Mutex
+	// configs maps the UIDs of the watched PromtailConfigs to the
+	// generations registered last.
+	configs map[types.UID]trackedConfig
+}
+
+type trackedConfig struct {
+	namespace  string
+	name       string
+	generation int64
+	key        promtailconfig.Key
+	// snippet is the canonical snippet registered for key.
+	snippet string
+	// reported is the generation last set in the status.
+	reported int64
+}
+
+func NewTracker(config TrackerConfig) (*Tracker, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	t := &Tracker{
+		k8sClient: config.K8sClient,
+		logger:    config.Logger,
+
+		configs: make(map[types.UID]trackedConfig),
+	}
+
+	return t, nil
+}
+
+// Watch makes the tracker set the generation of cr once snippet is rendered
+// for key. snippet must be the canonical snippet held by the handler.
+func (t *Tracker) Watch(cr *v1alpha1.PromtailConfig, key promtailconfig.Key, snippet string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.configs[cr.UID] = trackedConfig{
+		namespace:  cr.Namespace,
+		name:       cr.Name,
+		generation: cr.Generation,
+		key:        key,
+		snippet:    snippet,
+		reported:   cr.Status.RenderedGeneration,
+	}
+}
+
+// Forget stops tracking the PromtailConfig identified by source.
+func (t *Tracker) Forget(source types.UID) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.configs, source)
+}
+
+// Rendered sets the generation of every watched PromtailConfig whose snippet
+// got rendered into promtail's ConfigMap since it was reported last. The
+// statuses are updated in the background.
+func (t *Tracker) Rendered(registrations []promtailconfig.Registration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var rendered []types.UID
+	for _, reg := range registrations {
+		c, found := t.configs[reg.Source]
+		if !found || c.key != reg.Key || c.snippet != reg.Snippet || c.generation <= c.reported {
+			continue
+		}
+		rendered = append(rendered, reg.Source)
+	}
+
+	if len(rendered) > 0 {
+		go t.report(context.Background(), rendered)
+	}
+}
+
+// report sets the generations of the PromtailConfigs identified by sources in
+// their statuses.
+func (t *Tracker) report(ctx context.Context, sources []types.UID) {
+	for _, source := range sources {
+		t.mutex.Lock()
+		c, found := t.configs[source]
+		t.mutex.Unlock()
+		if !found {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			return t.updateRenderedGeneration(ctx, source, c)
+		})
+		if err != nil {
+			t.logger.LogCtx(ctx, "level", "error", "message", "failed to update rendered generation of PromtailConfig",
+				"namespace", c.namespace, "name", c.name, "stack", microerror.Stack(err))
+			continue
+		}
+
+		t.mutex.Lock()
+		if current, found := t.configs[source]; found && current.generation == c.generation {
+			current.reported = c.generation
+			t.configs[source] = current
+		}
+		t.mutex.Unlock()
+	}
+}
+
+func (t *Tracker) updateRenderedGeneration(ctx context.Context, source types.UID, c trackedConfig) error {
+	var cr v1alpha1.PromtailConfig
+	err := t.k8sClient.CtrlClient().Get(ctx, client.ObjectKey{Namespace: c.namespace, Name: c.name}, &cr)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if cr.UID != source || cr.Status.RenderedGeneration >= c.generation {
+		return nil
+	}
+
+	cr.Status.RenderedGeneration = c.generation
+	err = t.k8sClient.CtrlClient().Status().Update(ctx, &cr)
+	if err != nil {
+		// The error isn't masked, RetryOnConflict must recognize conflicts.
+		return err
+	}
+
+	t.logger.LogCtx(ctx, "level", "debug", "message", "updated rendered generation of PromtailConfig",
+		"namespace", c.namespace, "name", c.name, "generation", c.generation)
+
+	return nil
+}
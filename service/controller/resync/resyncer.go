@@ -0,0 +1,441 @@
+// Package resync periodically rebuilds the snippets of all the pods carrying
+// the promtail label and of all the PromtailConfigs, and reconciles them with
+// the registered snippets and promtail's ConfigMap.
+package resync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/k8sclient"
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+	"github.com/giantswarm/loki-operator/service/controller/resource/promtailconfigcr"
+	"github.com/giantswarm/loki-operator/service/controller/snippetwatcher"
+)
+
+const (
+	// retryInterval is the time to wait before retrying a failed resync,
+	// if it is shorter than the configured interval.
+	retryInterval = 30 * time.Second
+)
+
+type Config struct {
+	K8sClient         k8sclient.Interface
+	Logger            micrologger.Logger
+	Handler           promtailconfig.Handler
+	PodConfig         *podconfig.Resolver
+	PromtailConfigMap *promtailconfig.PromtailConfigMap
+	Scope             *namespacescope.Scope
+	Watcher           *snippetwatcher.Watcher
+
+	// Interval is the time between two resyncs.
+	Interval time.Duration
+}
+
+// Resyncer registers the snippets of pods missed by the pod controller, e.g.
+// while the operator wasn't running, and retracts the snippets of sources
+// which don't exist anymore.
+type Resyncer struct {
+	k8sClient         k8sclient.Interface
+	logger            micrologger.Logger
+	handler           promtailconfig.Handler
+	podConfig         *podconfig.Resolver
+	promtailConfigMap *promtailconfig.PromtailConfigMap
+	scope             *namespacescope.Scope
+	watcher           *snippetwatcher.Watcher
+
+	interval time.Duration
+	// trigger requests a resync before the interval is over.
+	trigger chan struct{}
+}
+
+type registrationID struct {
+	Key    promtailconfig.Key
+	Source types.UID
+}
+
+// sourceSet holds the sources found by a resync and the snippets they desire.
+type sourceSet struct {
+	// desired are the snippets of all the sources.
+	desired map[registrationID]string
+	// sources are the pods and PromtailConfigs of the namespaces in the
+	// operator's scope.
+	sources map[types.UID]bool
+	// resolvedSources are the sources whose snippets were all loaded, so
+	// their registrations not desired anymore can be retracted.
+	resolvedSources map[types.UID]bool
+	// excludedSources are the pods and PromtailConfigs of namespaces
+	// excluded from the operator's scope. Their snippets are retracted.
+	excludedSources map[types.UID]bool
+}
+
+func New(config Config) (*Resyncer, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Handler == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Handler must not be empty", config)
+	}
+	if config.PodConfig == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PodConfig must not be empty", config)
+	}
+	if config.PromtailConfigMap == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PromtailConfigMap must not be empty", config)
+	}
+	if config.Scope == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Scope must not be empty", config)
+	}
+	if config.Watcher == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Watcher must not be empty", config)
+	}
+
+	if config.Interval <= 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Interval must be greater than 0", config)
+	}
+
+	r := &Resyncer{
+		k8sClient:         config.K8sClient,
+		logger:            config.Logger,
+		handler:           config.Handler,
+		podConfig:         config.PodConfig,
+		promtailConfigMap: config.PromtailConfigMap,
+		scope:             config.Scope,
+		watcher:           config.Watcher,
+
+		interval: config.Interval,
+		trigger:  make(chan struct{}, 1),
+	}
+
+	// Namespaces included or excluded are picked up right away.
+	r.scope.OnChange(r.Trigger)
+
+	return r, nil
+}
+
+// Trigger requests a resync without waiting for the interval to pass.
+// Requests made while a resync is pending are coalesced.
+func (r *Resyncer) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Boot resyncs right away and then on every interval until ctx is canceled.
+// Failed resyncs are retried sooner.
+func (r *Resyncer) Boot(ctx context.Context) {
+	if !r.scope.WaitForCacheSync(ctx) {
+		return
+	}
+
+	for {
+		delay := r.interval
+
+		err := r.Resync(ctx)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to resync promtail snippets", "stack", microerror.Stack(err))
+			if retryInterval < delay {
+				delay = retryInterval
+			}
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-r.trigger:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Resync rebuilds the snippets of all the pods carrying the promtail label and
+// of all the PromtailConfigs and diffs them against the snippets Load parses out of promtail's live
+// ConfigMap. Snippets missing in the ConfigMap or differing from it are
+// registered, the jobs of the ConfigMap nothing desires anymore, e.g. the
+// ones of pods deleted while the operator wasn't running, are collected.
+// Snippets registered for pods or PromtailConfigs which don't exist anymore,
+// or for Keys a pod doesn't have anymore, are unregistered. At last, the
+// handler is told to write promtail's ConfigMap, which drops the collected
+// jobs from it.
+func (r *Resyncer) Resync(ctx context.Context) error {
+	r.logger.LogCtx(ctx, "level", "debug", "message", "resyncing promtail snippets")
+
+	// The registrations and the ConfigMap are read before listing the
+	// sources, so sources created in between are never taken for orphans.
+	current := map[registrationID]string{}
+	for _, reg := range r.handler.Registrations() {
+		current[registrationID{Key: reg.Key, Source: reg.Source}] = reg.Snippet
+	}
+	live, err := r.promtailConfigMap.Load()
+	if promtailconfig.IsInvalidState(err) {
+		// A broken state must not keep the handler from ever writing
+		// promtail's ConfigMap, the write replaces the state. Jobs of the
+		// ConfigMap nothing desires are dropped with it anyway.
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to load the state of promtail config map, taking it for empty",
+			"stack", microerror.Stack(err))
+		live = map[promtailconfig.Key]string{}
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	// Only the namespaces the operator is restricted to are listed, the
+	// sources of other namespaces are taken for orphans.
+	var pods []v1.Pod
+	var promtailConfigs []v1alpha1.PromtailConfig
+	for _, namespace := range r.scope.Namespaces() {
+		podList, err := r.k8sClient.K8sClient().CoreV1().Pods(namespace).List(metav1.ListOptions{
+			LabelSelector: podconfig.PromtailConfigLabel,
+		})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		pods = append(pods, podList.Items...)
+
+		promtailConfigList := &v1alpha1.PromtailConfigList{}
+		err = r.k8sClient.CtrlClient().List(ctx, promtailConfigList, client.InNamespace(namespace))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		promtailConfigs = append(promtailConfigs, promtailConfigList.Items...)
+	}
+
+	set := sourceSet{
+		desired:         map[registrationID]string{},
+		sources:         map[types.UID]bool{},
+		resolvedSources: map[types.UID]bool{},
+		excludedSources: map[types.UID]bool{},
+	}
+
+	// PromtailConfigs are registered here as well as by their controller,
+	// which only runs on the leader, so the registries of the other
+	// replicas hold their snippets when they take over.
+	for i := range promtailConfigs {
+		cr := &promtailConfigs[i]
+		if cr.DeletionTimestamp != nil {
+			continue
+		}
+		err = r.scope.Check(cr.Namespace)
+		if namespacescope.IsExcludedNamespace(err) {
+			set.excludedSources[cr.UID] = true
+			continue
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+		set.sources[cr.UID] = true
+		set.resolvedSources[cr.UID] = true
+
+		r.desiredPromtailConfigSnippet(ctx, cr, set.desired)
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		err = r.scope.Check(pod.Namespace)
+		if namespacescope.IsExcludedNamespace(err) {
+			set.excludedSources[pod.UID] = true
+			continue
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+		set.sources[pod.UID] = true
+
+		if r.desiredSnippets(ctx, pod, set.desired) {
+			set.resolvedSources[pod.UID] = true
+		}
+	}
+
+	err = r.reconcile(ctx, current, live, set)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for id := range current {
+		if !set.sources[id.Source] {
+			r.watcher.Untrack(id.Source)
+		}
+	}
+
+	// Jobs of the ConfigMap not registered in the handler are dropped with
+	// this write.
+	r.handler.Synced()
+
+	r.logger.LogCtx(ctx, "level", "debug", "message", "resynced promtail snippets")
+
+	return nil
+}
+
+// reconcile diffs the snippets desired by set against the registered snippets
+// in current and the jobs of promtail's ConfigMap in live. Desired snippets
+// not registered yet are registered, the snippets of jobs nothing desires
+// anymore and the snippets of sources which don't exist anymore are
+// unregistered.
+func (r *Resyncer) reconcile(ctx context.Context, current map[registrationID]string, live map[promtailconfig.Key]string, set sourceSet) error {
+	var err error
+
+	desired := set.desired
+	sources := set.sources
+	resolvedSources := set.resolvedSources
+
+	// kept are the Keys whose jobs stay in promtail's ConfigMap: the ones
+	// desired and the ones of sources whose snippets couldn't all be
+	// loaded, which keep their former snippets.
+	kept := map[promtailconfig.Key]bool{}
+	for id := range desired {
+		kept[id.Key] = true
+	}
+	for id := range current {
+		if sources[id.Source] && !resolvedSources[id.Source] {
+			kept[id.Key] = true
+		}
+	}
+
+	for id, snippet := range desired {
+		// Snippets registered already are never registered again, even
+		// when the job in the ConfigMap differs because another source
+		// of the Key won a conflict. Registering them again would make
+		// the winner flip on every resync. Registered snippets missing
+		// in the ConfigMap are written by Synced.
+		registered, inRegistry := current[id]
+		if inRegistry && registered == snippet {
+			continue
+		}
+		liveSnippet, inLive := live[id.Key]
+
+		var message string
+		if !inLive {
+			message = "adding missing job to promtail config map"
+		} else if liveSnippet != snippet {
+			message = "updating changed job in promtail config map"
+		} else {
+			message = "registering snippet of job in promtail config map"
+		}
+		r.logger.LogCtx(ctx, "level", "info", "message", message, "key", fmt.Sprintf("%+v", id.Key), "source", string(id.Source))
+
+		err = r.handler.AddConfig(id.Key, id.Source, snippet)
+		if promtailconfig.IsInvalidSnippet(err) {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "rejected promtail snippet", "reason", err.Error())
+		} else if promtailconfig.IsSnippetConflict(err) {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "pods with the same key use different snippets",
+				"reason", err.Error())
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	for key := range live {
+		if kept[key] {
+			continue
+		}
+
+		r.logger.LogCtx(ctx, "level", "info", "message", "removing orphaned job from promtail config map",
+			"key", fmt.Sprintf("%+v", key))
+		for id := range current {
+			if id.Key == key {
+				r.handler.DelConfig(id.Key, id.Source)
+			}
+		}
+	}
+
+	for id := range current {
+		if sources[id.Source] {
+			if !resolvedSources[id.Source] {
+				continue
+			}
+			if _, found := desired[id]; found {
+				continue
+			}
+
+			r.logger.LogCtx(ctx, "level", "info", "message", "unregistering stale snippet",
+				"key", fmt.Sprintf("%+v", id.Key), "source", string(id.Source))
+			r.handler.DelConfig(id.Key, id.Source)
+			continue
+		}
+
+		message := "unregistering orphaned snippet"
+		if set.excludedSources[id.Source] {
+			message = "unregistering snippet of excluded namespace"
+		}
+		r.logger.LogCtx(ctx, "level", "info", "message", message,
+			"key", fmt.Sprintf("%+v", id.Key), "source", string(id.Source))
+		r.handler.DelConfig(id.Key, id.Source)
+	}
+
+	return nil
+}
+
+// desiredSnippets adds the snippets of all the logging containers of pod to
+// desired. It returns whether all of them were loaded.
+func (r *Resyncer) desiredSnippets(ctx context.Context, pod *v1.Pod, desired map[registrationID]string) bool {
+	configs, err := r.podConfig.ContainerConfigs(pod)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "warning", "message", "skipping pod with invalid promtail config",
+			"pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "reason", err.Error())
+		return false
+	}
+
+	resolved := true
+	for _, c := range configs {
+		r.watcher.Track(pod.Namespace, c.ConfigMapName, snippetwatcher.Dependent{
+			Key:          c.Key,
+			Source:       pod.UID,
+			ConfigMapKey: c.ConfigMapKey,
+		})
+
+		snippet, err := r.podConfig.LoadSnippet(pod.Namespace, c)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "failed to load promtail snippet of pod",
+				"pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "reason", err.Error())
+			resolved = false
+			continue
+		}
+
+		canonical, err := promtailconfig.CanonicalSnippet(snippet)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "rejected promtail snippet of pod",
+				"pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "reason", err.Error())
+			resolved = false
+			continue
+		}
+
+		desired[registrationID{Key: c.Key, Source: pod.UID}] = canonical
+	}
+
+	return resolved
+}
+
+// desiredPromtailConfigSnippet adds the snippet of cr to desired. Rejected
+// PromtailConfigs desire no snippet, as their controller retracts it too, and
+// are reported by their controller.
+func (r *Resyncer) desiredPromtailConfigSnippet(ctx context.Context, cr *v1alpha1.PromtailConfig, desired map[registrationID]string) {
+	snippet, key, err := promtailconfigcr.Snippet(cr)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "debug", "message", "skipping invalid PromtailConfig",
+			"promtailconfig", fmt.Sprintf("%s/%s", cr.Namespace, cr.Name), "reason", err.Error())
+		return
+	}
+
+	canonical, err := promtailconfig.CanonicalSnippet(snippet)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "debug", "message", "skipping rejected promtail snippet of PromtailConfig",
+			"promtailconfig", fmt.Sprintf("%s/%s", cr.Namespace, cr.Name), "reason", err.Error())
+		return
+	}
+
+	desired[registrationID{Key: *key, Source: cr.UID}] = canonical
+}
@@ -0,0 +1,244 @@
+package resync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/giantswarm/micrologger/microloggertest"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+// fakeHandler is a promtailconfig.Handler keeping the snippets in a Registry
+// and counting the changes made to it.
+type fakeHandler struct {
+	registry *promtailconfig.Registry
+	adds     int
+	dels     int
+}
+
+func newFakeHandler() *fakeHandler {
+	return &fakeHandler{
+		registry: promtailconfig.NewRegistry(),
+	}
+}
+
+func (h *fakeHandler) AddConfig(key promtailconfig.Key, source types.UID, yamlContent string) error {
+	h.adds++
+	return h.registry.Register(key, source, yamlContent)
+}
+
+func (h *fakeHandler) DelConfig(key promtailconfig.Key, source types.UID) {
+	h.dels++
+	h.registry.Unregister(key, source)
+}
+
+func (h *fakeHandler) DelSource(source types.UID) {
+	h.dels++
+	h.registry.UnregisterSource(source)
+}
+
+func (h *fakeHandler) RetainConfigs(source types.UID, keys []promtailconfig.Key) {
+	h.registry.RetainSource(source, keys)
+}
+
+func (h *fakeHandler) Registrations() []promtailconfig.Registration {
+	return h.registry.Registrations()
+}
+
+func (h *fakeHandler) Synced()                  {}
+func (h *fakeHandler) Refresh()                 {}
+func (h *fakeHandler) Boot(ctx context.Context) {}
+
+// current returns the registered snippets the way Resync reads them.
+func (h *fakeHandler) current() map[registrationID]string {
+	current := map[registrationID]string{}
+	for _, reg := range h.registry.Registrations() {
+		current[registrationID{Key: reg.Key, Source: reg.Source}] = reg.Snippet
+	}
+
+	return current
+}
+
+var (
+	keyWeb = promtailconfig.Key{Namespace: "default", Workload: "Deployment/web", Labels: "app=web", ContainerName: "nginx"}
+	keyAPI = promtailconfig.Key{Namespace: "default", Workload: "Deployment/api", Labels: "app=api", ContainerName: "api"}
+)
+
+func newSourceSet(desired map[registrationID]string, unresolved ...types.UID) sourceSet {
+	set := sourceSet{
+		desired:         desired,
+		sources:         map[types.UID]bool{},
+		resolvedSources: map[types.UID]bool{},
+		excludedSources: map[types.UID]bool{},
+	}
+	for id := range desired {
+		set.sources[id.Source] = true
+		set.resolvedSources[id.Source] = true
+	}
+	for _, source := range unresolved {
+		set.sources[source] = true
+		delete(set.resolvedSources, source)
+	}
+
+	return set
+}
+
+// Test_Resyncer_reconcile_Conflict ensures resyncs don't flip the winner of
+// a conflict between two sources of the same Key.
+func Test_Resyncer_reconcile_Conflict(t *testing.T) {
+	handler := newFakeHandler()
+	r := &Resyncer{
+		logger:  microloggertest.New(),
+		handler: handler,
+	}
+
+	set := newSourceSet(map[registrationID]string{
+		{Key: keyWeb, Source: "pod-1"}: "old",
+		{Key: keyWeb, Source: "pod-2"}: "new",
+	})
+
+	err := r.reconcile(context.Background(), handler.current(), map[promtailconfig.Key]string{}, set)
+	if err != nil {
+		t.Fatalf("expected no error, got %#q", err)
+	}
+	if handler.adds != 2 {
+		t.Fatalf("expected both snippets to be registered by the first resync, got %d registrations", handler.adds)
+	}
+
+	// promtail's ConfigMap holds the winner's job after the first resync.
+	live := handler.registry.Snippets()
+
+	for i := 0; i < 3; i++ {
+		handler.adds = 0
+
+		err = r.reconcile(context.Background(), handler.current(), live, set)
+		if err != nil {
+			t.Fatalf("expected no error, got %#q", err)
+		}
+		if handler.adds != 0 || handler.dels != 0 {
+			t.Fatalf("expected resync %d to change nothing, got %d registrations and %d unregistrations", i+2, handler.adds, handler.dels)
+		}
+		if snippets := handler.registry.Snippets(); !reflect.DeepEqual(snippets, live) {
+			t.Fatalf("expected resync %d to keep the snippets %#v, got %#v", i+2, live, snippets)
+		}
+	}
+}
+
+func Test_Resyncer_reconcile(t *testing.T) {
+	testCases := []struct {
+		name string
+		// registered are the snippets registered before the resync.
+		registered map[registrationID]string
+		live       map[promtailconfig.Key]string
+		set        sourceSet
+		// expectedAdds is the number of snippets expected to be registered
+		// by the resync.
+		expectedAdds     int
+		expectedSnippets map[promtailconfig.Key]string
+	}{
+		{
+			name: "case 0: missing snippets registered",
+			set: newSourceSet(map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "web",
+				{Key: keyAPI, Source: "pod-2"}: "api",
+			}),
+			expectedAdds: 2,
+			expectedSnippets: map[promtailconfig.Key]string{
+				keyWeb: "web",
+				keyAPI: "api",
+			},
+		},
+		{
+			name: "case 1: registered snippets missing in the ConfigMap not registered again",
+			registered: map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "web",
+			},
+			set: newSourceSet(map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "web",
+			}),
+			expectedAdds: 0,
+			expectedSnippets: map[promtailconfig.Key]string{
+				keyWeb: "web",
+			},
+		},
+		{
+			name: "case 2: changed snippet registered",
+			registered: map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "old",
+			},
+			live: map[promtailconfig.Key]string{
+				keyWeb: "old",
+			},
+			set: newSourceSet(map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "new",
+			}),
+			expectedAdds: 1,
+			expectedSnippets: map[promtailconfig.Key]string{
+				keyWeb: "new",
+			},
+		},
+		{
+			name: "case 3: snippets of deleted sources unregistered",
+			registered: map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "web",
+				{Key: keyAPI, Source: "pod-2"}: "api",
+			},
+			live: map[promtailconfig.Key]string{
+				keyWeb: "web",
+				keyAPI: "api",
+			},
+			set: newSourceSet(map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "web",
+			}),
+			expectedAdds: 0,
+			expectedSnippets: map[promtailconfig.Key]string{
+				keyWeb: "web",
+			},
+		},
+		{
+			name: "case 4: former snippets of unresolved sources kept",
+			registered: map[registrationID]string{
+				{Key: keyWeb, Source: "pod-1"}: "web",
+			},
+			live: map[promtailconfig.Key]string{
+				keyWeb: "web",
+			},
+			set:          newSourceSet(map[registrationID]string{}, "pod-1"),
+			expectedAdds: 0,
+			expectedSnippets: map[promtailconfig.Key]string{
+				keyWeb: "web",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newFakeHandler()
+			for id, snippet := range tc.registered {
+				err := handler.registry.Register(id.Key, id.Source, snippet)
+				if err != nil {
+					t.Fatalf("expected no error registering the snippets, got %#q", err)
+				}
+			}
+			r := &Resyncer{
+				logger:  microloggertest.New(),
+				handler: handler,
+			}
+
+			err := r.reconcile(context.Background(), handler.current(), tc.live, tc.set)
+			if err != nil {
+				t.Fatalf("expected no error, got %#q", err)
+			}
+
+			if handler.adds != tc.expectedAdds {
+				t.Fatalf("expected %d registrations, got %d", tc.expectedAdds, handler.adds)
+			}
+			if snippets := handler.registry.Snippets(); !reflect.DeepEqual(snippets, tc.expectedSnippets) {
+				t.Fatalf("expected snippets %#v, got %#v", tc.expectedSnippets, snippets)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+// Package snippetwatcher watches the ConfigMaps holding promtail snippets and
+// pushes their changes into the promtail config handler.
+package snippetwatcher
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+const (
+	// resyncPeriod is zero, because the watcher only acts on changes of
+	// ConfigMaps. Snippets are resynced from the pods.
+	resyncPeriod = 0
+)
+
+// Dependent is a snippet registered in the handler from a key of a ConfigMap.
+type Dependent struct {
+	Key          promtailconfig.Key
+	Source       types.UID
+	ConfigMapKey string
+}
+
+type Config struct {
+	K8sClient kubernetes.Interface
+	Logger    micrologger.Logger
+	Handler   promtailconfig.Handler
+	PodConfig *podconfig.Resolver
+
+	// LabelSelector makes the watcher watch all the ConfigMaps matching the
+	// selector with a single informer per namespace, the ones not
+	// referenced from any pod are ignored. When empty, only the ConfigMaps
+	// referenced from pods are watched, each one on its own.
+	LabelSelector string
+	// Namespaces are the namespaces watched with the label selector, see
+	// namespacescope.Scope.Namespaces.
+	Namespaces []string
+}
+
+// Watcher keeps a reverse index from ConfigMaps to the snippets registered
+// from them. When a ConfigMap changes, the updated content of its keys is
+// pushed into the handler. When it is deleted, the snippets depending on it
+// are retracted until it is created again.
+type Watcher struct {
+	handler   promtailconfig.Handler
+	k8sClient kubernetes.Interface
+	logger    micrologger.Logger
+	podConfig *podconfig.Resolver
+
+	// informers watch the ConfigMaps matching the label selector, one per
+	// namespace. It is empty when no selector is configured.
+	informers []cache.SharedIndexInformer
+
+	// mutex guards dependents, done and watches. It also serializes the
+	// pushes into the handler with tracking changes, so no snippet is pushed
+	// for a source after it was untracked. It is never held while calling
+	// the Kubernetes API.
+	mutex sync.Mutex
+	// dependents maps ConfigMaps to the snippets registered from them.
+	dependents map[types.NamespacedName]map[dependentID]Dependent
+	// done is closed when the watcher stops. It is nil until Boot is
+	// called.
+	done <-chan struct{}
+	// watches holds the channels stopping the informers of the ConfigMaps
+	// referenced from pods, when no label selector is configured.
+	watches map[types.NamespacedName]chan struct{}
+}
+
+type dependentID struct {
+	Key    promtailconfig.Key
+	Source types.UID
+}
+
+func New(config Config) (*Watcher, error) {
+	if config.K8sClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.K8sClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Handler == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Handler must not be empty", config)
+	}
+	if config.PodConfig == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.PodConfig must not be empty", config)
+	}
+	if config.LabelSelector != "" && len(config.Namespaces) == 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Namespaces must not be empty when %T.LabelSelector is set", config, config)
+	}
+
+	w := &Watcher{
+		handler:   config.Handler,
+		k8sClient: config.K8sClient,
+		logger:    config.Logger,
+		podConfig: config.PodConfig,
+
+		dependents: make(map[types.NamespacedName]map[dependentID]Dependent),
+		watches:    make(map[types.NamespacedName]chan struct{}),
+	}
+
+	if config.LabelSelector != "" {
+		for _, namespace := range config.Namespaces {
+			informer := w.newInformer(namespace, func(options *metav1.ListOptions) {
+				options.LabelSelector = config.LabelSelector
+			})
+			w.informers = append(w.informers, informer)
+		}
+	}
+
+	return w, nil
+}
+
+// Boot runs the ConfigMap informers until ctx is canceled.
+func (w *Watcher) Boot(ctx context.Context) {
+	w.mutex.Lock()
+	w.done = ctx.Done()
+	for ref := range w.dependents {
+		w.watch(ref)
+	}
+	w.mutex.Unlock()
+
+	for _, informer := range w.informers {
+		go informer.Run(ctx.Done())
+	}
+
+	<-ctx.Done()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for ref, stop := range w.watches {
+		close(stop)
+		delete(w.watches, ref)
+	}
+}
+
+func (w *Watcher) newInformer(namespace string, optionsModifier func(options *metav1.ListOptions)) cache.SharedIndexInformer {
+	lw := cache.NewFilteredListWatchFromClient(w.k8sClient.CoreV1().RESTClient(), "configmaps", namespace, optionsModifier)
+	informer := cache.NewSharedIndexInformer(lw, &v1.ConfigMap{}, resyncPeriod, cache.Indexers{})
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.addFunc,
+		UpdateFunc: w.updateFunc,
+		DeleteFunc: w.deleteFunc,
+	})
+
+	return informer
+}
+
+// watch starts the informer of the ConfigMap ref, unless a label selector is
+// configured, the watcher isn't booted yet or has stopped, or ref is watched
+// already. The caller must hold the mutex.
+func (w *Watcher) watch(ref types.NamespacedName) {
+	if len(w.informers) > 0 || w.done == nil {
+		return
+	}
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	if _, found := w.watches[ref]; found {
+		return
+	}
+
+	informer := w.newInformer(ref.Namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ref.Name).String()
+	})
+	stop := make(chan struct{})
+	w.watches[ref] = stop
+
+	go informer.Run(stop)
+}
+
+// forget drops ref from the index once no snippet depends on it anymore and
+// stops its informer. The caller must hold the mutex.
+func (w *Watcher) forget(ref types.NamespacedName) {
+	if len(w.dependents[ref]) > 0 {
+		return
+	}
+	delete(w.dependents, ref)
+
+	if stop, found := w.watches[ref]; found {
+		close(stop)
+		delete(w.watches, ref)
+	}
+}
+
+// Track records that the snippet of dependent is read from the ConfigMap
+// namespace/name. Tracking a ConfigMap which doesn't exist yet is fine, the
+// snippet is pushed as soon as it gets created.
+func (w *Watcher) Track(namespace, name string, dependent Dependent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ref := types.NamespacedName{Namespace: namespace, Name: name}
+	id := dependentID{Key: dependent.Key, Source: dependent.Source}
+
+	// A container of a pod gets its snippet from a single ConfigMap, so
+	// tracking it again replaces any former reference.
+	for otherRef, dependents := range w.dependents {
+		if otherRef != ref {
+			delete(dependents, id)
+			w.forget(otherRef)
+		}
+	}
+
+	if w.dependents[ref] == nil {
+		w.dependents[ref] = make(map[dependentID]Dependent)
+	}
+	w.dependents[ref][id] = dependent
+	w.watch(ref)
+}
+
+// Untrack forgets all the snippets registered for source.
+func (w *Watcher) Untrack(source types.UID) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for ref, dependents := range w.dependents {
+		for id := range dependents {
+			if id.Source == source {
+				delete(dependents, id)
+			}
+		}
+		w.forget(ref)
+	}
+}
+
+// Retain forgets the snippets registered for source with Keys other than
+// keys.
+func (w *Watcher) Retain(source types.UID, keys []promtailconfig.Key) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	retained := make(map[promtailconfig.Key]bool, len(keys))
+	for _, key := range keys {
+		retained[key] = true
+	}
+
+	for ref, dependents := range w.dependents {
+		for id := range dependents {
+			if id.Source == source && !retained[id.Key] {
+				delete(dependents, id)
+			}
+		}
+		w.forget(ref)
+	}
+}
+
+func (w *Watcher) addFunc(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+
+	w.push(cm)
+}
+
+func (w *Watcher) updateFunc(oldObj, newObj interface{}) {
+	oldCM, ok := oldObj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+	newCM, ok := newObj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+	if reflect.DeepEqual(oldCM.Data, newCM.Data) {
+		return
+	}
+
+	w.push(newCM)
+}
+
+func (w *Watcher) deleteFunc(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		cm, ok = tombstone.Obj.(*v1.ConfigMap)
+		if !ok {
+			return
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ref := types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}
+	for _, d := range w.dependents[ref] {
+		w.logger.Log("level", "debug", "message", "retracting snippet of deleted ConfigMap", "configmap", ref.String(),
+			"source", string(d.Source))
+		w.handler.DelConfig(d.Key, d.Source)
+	}
+}
+
+// push registers the current content of cm for all the snippets depending on
+// it. Snippets whose key is gone from cm are retracted. The snippets are
+// collected under the mutex, but reported without holding it, so a slow API
+// server doesn't stall the handling of other events.
+func (w *Watcher) push(cm *v1.ConfigMap) {
+	ref := types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}
+
+	w.mutex.Lock()
+	var dependents []Dependent
+	for _, d := range w.dependents[ref] {
+		dependents = append(dependents, d)
+	}
+	w.mutex.Unlock()
+
+	for _, d := range dependents {
+		content, found := cm.Data[d.ConfigMapKey]
+		pushed, err := w.pushSnippet(ref, d, content, found)
+		if !pushed {
+			continue
+		}
+
+		if promtailconfig.IsInvalidSnippet(err) {
+			w.logger.Log("level", "warning", "message", "rejected changed snippet, keeping the former one", "configmap", ref.String(),
+				"key", d.ConfigMapKey, "reason", err.Error())
+			w.report(cm, d.ConfigMapKey, err)
+		} else if promtailconfig.IsSnippetConflict(err) {
+			// The snippet is registered nonetheless.
+			w.logger.Log("level", "warning", "message", "pods with the same key use different snippets", "reason", err.Error())
+			w.report(cm, d.ConfigMapKey, nil)
+		} else if err != nil {
+			w.logger.Log("level", "error", "message", "failed to push changed snippet", "stack", microerror.Stack(err))
+		} else {
+			w.report(cm, d.ConfigMapKey, nil)
+		}
+	}
+}
+
+// pushSnippet registers content for d, or retracts the snippet of d when its
+// key was not found in the ConfigMap ref. It returns whether content was
+// handed to the handler, which is not the case when d was untracked in the
+// meantime.
+func (w *Watcher) pushSnippet(ref types.NamespacedName, d Dependent, content string, found bool) (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, tracked := w.dependents[ref][dependentID{Key: d.Key, Source: d.Source}]; !tracked {
+		return false, nil
+	}
+
+	if !found {
+		w.logger.Log("level", "warning", "message", "retracting snippet missing in ConfigMap", "configmap", ref.String(),
+			"key", d.ConfigMapKey, "source", string(d.Source))
+		w.handler.DelConfig(d.Key, d.Source)
+		return false, nil
+	}
+
+	w.logger.Log("level", "debug", "message", "pushing changed snippet of ConfigMap", "configmap", ref.String(),
+		"key", d.ConfigMapKey, "source", string(d.Source))
+	err := w.handler.AddConfig(d.Key, d.Source, content)
+
+	return true, err
+}
+
+// report attaches the reason the snippet of key was rejected to cm, or clears
+// it when the snippet was accepted.
+func (w *Watcher) report(cm *v1.ConfigMap, key string, rejection error) {
+	err := w.podConfig.ReportSnippet(cm.Namespace, podconfig.ContainerConfig{ConfigMapName: cm.Name, ConfigMapKey: key}, rejection)
+	if err != nil {
+		w.logger.Log("level", "warning", "message", "failed to report snippet status on ConfigMap",
+			"configmap", fmt.Sprintf("%s/%s", cm.Namespace, cm.Name), "reason", err.Error())
+	}
+}
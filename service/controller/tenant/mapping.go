@@ -0,0 +1,106 @@
+package tenant
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Annotation of a namespace names the Loki tenant the logs of its pods
+	// are sent to.
+	Annotation = "giantswarm.io/loki-tenant"
+
+	// maxTenantLength is the maximum length of a tenant ID accepted by
+	// Loki.
+	maxTenantLength = 150
+)
+
+// validTenant matches the characters Loki accepts in tenant IDs.
+var validTenant = regexp.MustCompile(`^[a-zA-Z0-9!\-_.*'()]+$`)
+
+type MappingConfig struct {
+	// Default is the tenant of namespaces neither annotated nor listed in
+	// Mapping. Tenants are disabled when it is empty.
+	Default string
+	// Mapping lists the tenants of namespaces as "namespace=tenant".
+	Mapping []string
+}
+
+// Mapping maps namespaces to their tenants. It needs no access to the
+// cluster, the namespaces are passed in.
+type Mapping struct {
+	defaultTenant string
+	mapping       map[string]string
+}
+
+// NewMapping returns a Mapping after validating the default tenant and the
+// tenants of the mapping table.
+func NewMapping(config MappingConfig) (*Mapping, error) {
+	if config.Default == "" && len(config.Mapping) > 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Default must not be empty when %T.Mapping is set", config, config)
+	}
+	if config.Default != "" {
+		err := validateTenant(config.Default)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "%T.Default is invalid: %v", config, err)
+		}
+	}
+
+	mapping := map[string]string{}
+	for _, entry := range config.Mapping {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.Mapping entry %#q must look like namespace=tenant", config, entry)
+		}
+		err := validateTenant(parts[1])
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "%T.Mapping entry %#q is invalid: %v", config, entry, err)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+
+	m := &Mapping{
+		defaultTenant: config.Default,
+		mapping:       mapping,
+	}
+
+	return m, nil
+}
+
+// Enabled tells whether tenants are enabled, i.e. a default tenant is set.
+func (m *Mapping) Enabled() bool {
+	return m.defaultTenant != ""
+}
+
+// Tenant returns the tenant of ns, taken from its Annotation, from the mapping
+// table or, failing both, the default tenant. Invalid annotations are
+// ignored, the error tells why.
+func (m *Mapping) Tenant(ns *v1.Namespace) (string, error) {
+	var err error
+	if value, found := ns.Annotations[Annotation]; found {
+		err = validateTenant(value)
+		if err == nil {
+			return value, nil
+		}
+	}
+	if tenant, found := m.mapping[ns.Name]; found {
+		return tenant, err
+	}
+
+	return m.defaultTenant, err
+}
+
+func validateTenant(tenant string) error {
+	if len(tenant) > maxTenantLength {
+		return fmt.Errorf("tenant %#q is longer than %d characters", tenant, maxTenantLength)
+	}
+	if tenant == "." || tenant == ".." || !validTenant.MatchString(tenant) {
+		return fmt.Errorf("tenant %#q must only contain letters, digits and the characters !-_.*'()", tenant)
+	}
+
+	return nil
+}
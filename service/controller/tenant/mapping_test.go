@@ -0,0 +1,163 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_NewMapping(t *testing.T) {
+	testCases := []struct {
+		name         string
+		config       MappingConfig
+		enabled      bool
+		errorMatcher func(error) bool
+	}{
+		{
+			name:    "case 0: tenants disabled",
+			config:  MappingConfig{},
+			enabled: false,
+		},
+		{
+			name: "case 1: default tenant and mapping",
+			config: MappingConfig{
+				Default: "shared",
+				Mapping: []string{"team=team-a", "ops=ops_1"},
+			},
+			enabled: true,
+		},
+		{
+			name: "case 2: mapping without default tenant",
+			config: MappingConfig{
+				Mapping: []string{"team=team-a"},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 3: invalid default tenant",
+			config: MappingConfig{
+				Default: "shared/tenant",
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 4: mapping entry without namespace",
+			config: MappingConfig{
+				Default: "shared",
+				Mapping: []string{"=team-a"},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 5: mapping entry without separator",
+			config: MappingConfig{
+				Default: "shared",
+				Mapping: []string{"team"},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 6: mapping entry with invalid tenant",
+			config: MappingConfig{
+				Default: "shared",
+				Mapping: []string{"team=" + strings.Repeat("a", maxTenantLength+1)},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewMapping(tc.config)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("expected no error, got %#q", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("expected error, got nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("unexpected error %#v", err)
+			}
+
+			if err == nil && m.Enabled() != tc.enabled {
+				t.Fatalf("expected enabled %t, got %t", tc.enabled, m.Enabled())
+			}
+		})
+	}
+}
+
+func Test_Mapping_Tenant(t *testing.T) {
+	m, err := NewMapping(MappingConfig{
+		Default: "shared",
+		Mapping: []string{"team=team-a"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error creating the mapping, got %#q", err)
+	}
+
+	testCases := []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:      "case 0: namespace neither annotated nor mapped",
+			namespace: "default",
+			expected:  "shared",
+		},
+		{
+			name:      "case 1: mapped namespace",
+			namespace: "team",
+			expected:  "team-a",
+		},
+		{
+			name:        "case 2: annotation taking precedence over the mapping",
+			namespace:   "team",
+			annotations: map[string]string{Annotation: "team-b"},
+			expected:    "team-b",
+		},
+		{
+			name:        "case 3: invalid annotation of a mapped namespace ignored",
+			namespace:   "team",
+			annotations: map[string]string{Annotation: ".."},
+			expected:    "team-a",
+			expectError: true,
+		},
+		{
+			name:        "case 4: invalid annotation of a namespace not mapped ignored",
+			namespace:   "default",
+			annotations: map[string]string{Annotation: "team a"},
+			expected:    "shared",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns := &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        tc.namespace,
+					Annotations: tc.annotations,
+				},
+			}
+
+			tenant, err := m.Tenant(ns)
+
+			if tc.expectError && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Fatalf("expected no error, got %#q", err)
+			}
+			if tenant != tc.expected {
+				t.Fatalf("expected tenant %#q, got %#q", tc.expected, tenant)
+			}
+		})
+	}
+}
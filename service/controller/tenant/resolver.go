@@ -0,0 +1,110 @@
+// Package tenant maps namespaces to the Loki tenants their logs are sent to.
+// The tenant of a namespace is taken from its Annotation, from the mapping
+// table of the operator or, failing both, is the default tenant.
+package tenant
+
+import (
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+)
+
+type Config struct {
+	Logger  micrologger.Logger
+	Handler promtailconfig.Handler
+	Scope   *namespacescope.Scope
+
+	// Default is the tenant of namespaces neither annotated nor listed in
+	// Mapping. Tenants are disabled when it is empty.
+	Default string
+	// Mapping lists the tenants of namespaces as "namespace=tenant".
+	Mapping []string
+}
+
+// Resolver tells the tenant of every namespace, looking the namespaces up in
+// the cache of the scope. It implements promtailconfig.TenantResolver.
+type Resolver struct {
+	handler promtailconfig.Handler
+	logger  micrologger.Logger
+	mapping *Mapping
+	scope   *namespacescope.Scope
+}
+
+func New(config Config) (*Resolver, error) {
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Handler == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Handler must not be empty", config)
+	}
+	if config.Scope == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Scope must not be empty", config)
+	}
+
+	mapping, err := NewMapping(MappingConfig{
+		Default: config.Default,
+		Mapping: config.Mapping,
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	r := &Resolver{
+		handler: config.Handler,
+		logger:  config.Logger,
+		mapping: mapping,
+		scope:   config.Scope,
+	}
+
+	r.scope.OnNamespaceChange(r.namespaceChanged)
+
+	return r, nil
+}
+
+// Tenant returns the tenant of namespace, or an empty string when tenants are
+// disabled.
+func (r *Resolver) Tenant(namespace string) string {
+	if !r.mapping.Enabled() {
+		return ""
+	}
+
+	ns, found := r.scope.Get(namespace)
+	if !found {
+		ns = &v1.Namespace{}
+		ns.Name = namespace
+	}
+
+	tenant, _ := r.mapping.Tenant(ns)
+	return tenant
+}
+
+// namespaceChanged makes promtail's config to be rendered again when the
+// tenant of a namespace changed. Invalid annotations are reported when they
+// are set.
+func (r *Resolver) namespaceChanged(oldNamespace, newNamespace *v1.Namespace) {
+	if !r.mapping.Enabled() || newNamespace == nil {
+		return
+	}
+
+	newTenant, err := r.mapping.Tenant(newNamespace)
+	if oldNamespace == nil || oldNamespace.Annotations[Annotation] != newNamespace.Annotations[Annotation] {
+		if err != nil {
+			r.logger.Log("level", "warning", "message", fmt.Sprintf("ignoring %#q annotation of namespace %#q", Annotation,
+				newNamespace.Name), "reason", err.Error())
+		}
+	}
+	if oldNamespace != nil {
+		oldTenant, _ := r.mapping.Tenant(oldNamespace)
+		if oldTenant == newTenant {
+			return
+		}
+	}
+
+	r.logger.Log("level", "info", "message", fmt.Sprintf("logs of namespace %#q are sent to tenant %#q", newNamespace.Name, newTenant))
+	r.handler.Refresh()
+}
@@ -4,7 +4,10 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/giantswarm/k8sclient"
 	"github.com/giantswarm/k8sclient/k8srestconfig"
@@ -16,9 +19,22 @@ import (
 	"k8s.io/client-go/rest"
 
 	"github.com/giantswarm/loki-operator/flag"
+	"github.com/giantswarm/loki-operator/pkg/apis/loki/v1alpha1"
 	"github.com/giantswarm/loki-operator/pkg/project"
 	"github.com/giantswarm/loki-operator/service/collector"
 	"github.com/giantswarm/loki-operator/service/controller"
+	"github.com/giantswarm/loki-operator/service/controller/baseconfig"
+	"github.com/giantswarm/loki-operator/service/controller/events"
+	"github.com/giantswarm/loki-operator/service/controller/leader"
+	"github.com/giantswarm/loki-operator/service/controller/namespacescope"
+	"github.com/giantswarm/loki-operator/service/controller/podconfig"
+	"github.com/giantswarm/loki-operator/service/controller/podwatcher"
+	"github.com/giantswarm/loki-operator/service/controller/promtailconfig"
+	"github.com/giantswarm/loki-operator/service/controller/reloader"
+	"github.com/giantswarm/loki-operator/service/controller/resource/promtailconfigcr"
+	"github.com/giantswarm/loki-operator/service/controller/resync"
+	"github.com/giantswarm/loki-operator/service/controller/snippetwatcher"
+	"github.com/giantswarm/loki-operator/service/controller/tenant"
 )
 
 // Config represents the configuration used to create a new service.
@@ -32,9 +48,18 @@ type Config struct {
 type Service struct {
 	Version *version.Service
 
-	bootOnce          sync.Once
-	todoController    *controller.TODO
-	operatorCollector *collector.Set
+	baseConfigLoader         *baseconfig.Loader
+	bootOnce                 sync.Once
+	eventRecorder            *events.Recorder
+	leaderElector            *leader.Elector
+	namespaceScope           *namespacescope.Scope
+	promtailHandler          promtailconfig.Handler
+	promtailReloader         *reloader.Reloader
+	snippetWatcher           *snippetwatcher.Watcher
+	resyncer                 *resync.Resyncer
+	podWatcher               *podwatcher.Watcher
+	promtailConfigController *controller.PromtailConfig
+	operatorCollector        *collector.Set
 }
 
 // New creates a new configured service object.
@@ -79,6 +104,9 @@ func New(config Config) (*Service, error) {
 	{
 		c := k8sclient.ClientsConfig{
 			Logger: config.Logger,
+			SchemeBuilder: k8sclient.SchemeBuilder{
+				v1alpha1.AddToScheme,
+			},
 
 			RestConfig: restConfig,
 		}
@@ -89,31 +117,296 @@ func New(config Config) (*Service, error) {
 		}
 	}
 
-	var todoController *controller.TODO
+	var leaderElector *leader.Elector
 	{
+		identity, err := os.Hostname()
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		c := leader.Config{
+			K8sClient: k8sClient.K8sClient(),
+			Logger:    config.Logger,
+
+			Enabled:   config.Viper.GetBool(config.Flag.Loki.LeaderElection.Enabled),
+			Namespace: config.Viper.GetString(config.Flag.Loki.LeaderElection.Namespace),
+			Name:      config.Viper.GetString(config.Flag.Loki.LeaderElection.Name),
+			Identity:  identity,
+		}
+
+		leaderElector, err = leader.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	lokiOperatorConfig := controller.LokiOperatorConfig{
+		PromtailConfigmapNamespace: config.Viper.GetString(config.Flag.Loki.Namespace),
+		PromtailConfigmapName:      config.Viper.GetString(config.Flag.Loki.Name),
+		MaxWaitSec:                 config.Viper.GetInt(config.Flag.Loki.MaxWaitSec),
+		QuietPeriodSec:             config.Viper.GetInt(config.Flag.Loki.QuietPeriodSec),
+		PrivilegedNamespaces:       config.Viper.GetStringSlice(config.Flag.Loki.PrivilegedNamespaces),
+		AllowedLabels:              config.Viper.GetStringSlice(config.Flag.Loki.Labels.Allowed),
+		DeniedLabels:               config.Viper.GetStringSlice(config.Flag.Loki.Labels.Denied),
+		MaxLabels:                  config.Viper.GetInt(config.Flag.Loki.Labels.Max),
+	}
 
-		c := controller.TODOConfig{
+	var promtailConfigMap *promtailconfig.PromtailConfigMap
+	{
+		promtailConfigMap, err = controller.NewPromtailConfigMap(k8sClient, lokiOperatorConfig)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var namespaceScope *namespacescope.Scope
+	{
+		c := namespacescope.Config{
+			K8sClient: k8sClient.K8sClient(),
+			Logger:    config.Logger,
+
+			Namespaces:    config.Viper.GetStringSlice(config.Flag.Loki.Namespaces),
+			LabelSelector: config.Viper.GetString(config.Flag.Loki.NamespaceLabelSelector),
+		}
+
+		namespaceScope, err = namespacescope.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var promtailReloader *reloader.Reloader
+	{
+		c := reloader.Config{
+			K8sClient:         k8sClient.K8sClient(),
+			Logger:            config.Logger,
+			PromtailConfigMap: promtailConfigMap,
+
+			Mode:               config.Viper.GetString(config.Flag.Loki.ReloadMode),
+			DaemonSetNamespace: config.Viper.GetString(config.Flag.Loki.DaemonSetNamespace),
+			DaemonSetName:      config.Viper.GetString(config.Flag.Loki.DaemonSetName),
+			HTTPPort:           config.Viper.GetInt(config.Flag.Loki.HTTPPort),
+			MinInterval:        time.Duration(config.Viper.GetInt(config.Flag.Loki.ReloadMinIntervalSec)) * time.Second,
+		}
+
+		promtailReloader, err = reloader.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var eventRecorder *events.Recorder
+	{
+		c := events.Config{
+			K8sClient:     k8sClient.K8sClient(),
+			LeaderElector: leaderElector,
+			Logger:        config.Logger,
+
+			Component:         project.Name(),
+			PromtailConfigMap: fmt.Sprintf("%s/%s", lokiOperatorConfig.PromtailConfigmapNamespace, lokiOperatorConfig.PromtailConfigmapName),
+		}
+
+		eventRecorder, err = events.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var promtailConfigTracker *promtailconfigcr.Tracker
+	{
+		c := promtailconfigcr.TrackerConfig{
 			K8sClient: k8sClient,
 			Logger:    config.Logger,
-			Loki: controller.LokiOperatorConfig{
-				PromtailConfigmapNamespace: config.Viper.GetString(config.Flag.Loki.Namespace),
-				PromtailConfigmapName:      config.Viper.GetString(config.Flag.Loki.Name),
-				InitialDelaySec:            config.Viper.GetInt(config.Flag.Loki.InitialDelaySec),
-				PeriodSec:                  config.Viper.GetInt(config.Flag.Loki.PeriodSec),
-			},
 		}
 
-		todoController, err = controller.NewTODO(c)
+		promtailConfigTracker, err = promtailconfigcr.NewTracker(c)
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
 	}
 
-	var operatorCollector *collector.Set
+	var promtailHandler promtailconfig.Handler
 	{
-		c := collector.SetConfig{
+		observers := []promtailconfig.RenderObserver{
+			eventRecorder,
+			promtailConfigTracker,
+		}
+
+		promtailHandler, err = controller.NewHandler(promtailConfigMap, promtailReloader, observers, config.Logger, lokiOperatorConfig)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var tenantResolver *tenant.Resolver
+	{
+		c := tenant.Config{
+			Logger:  config.Logger,
+			Handler: promtailHandler,
+			Scope:   namespaceScope,
+
+			Default: config.Viper.GetString(config.Flag.Loki.Tenants.Default),
+			Mapping: config.Viper.GetStringSlice(config.Flag.Loki.Tenants.Mapping),
+		}
+
+		tenantResolver, err = tenant.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	promtailConfigMap.SetTenantResolver(tenantResolver)
+
+	var baseConfigLoader *baseconfig.Loader
+	{
+		inline, err := yamlValue(config.Viper, config.Flag.Loki.BaseConfig.Inline)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		mandatoryStages, err := yamlValue(config.Viper, config.Flag.Loki.BaseConfig.MandatoryStages)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		c := baseconfig.Config{
+			K8sClient:         k8sClient.K8sClient(),
+			Logger:            config.Logger,
+			Handler:           promtailHandler,
+			PromtailConfigMap: promtailConfigMap,
+
+			Inline:             inline,
+			ConfigMapNamespace: config.Viper.GetString(config.Flag.Loki.BaseConfig.Namespace),
+			ConfigMapName:      config.Viper.GetString(config.Flag.Loki.BaseConfig.Name),
+			ConfigMapKey:       config.Viper.GetString(config.Flag.Loki.BaseConfig.Key),
+
+			MandatoryStagesInline: mandatoryStages,
+			MandatoryStagesKey:    config.Viper.GetString(config.Flag.Loki.BaseConfig.MandatoryStagesKey),
+		}
+
+		baseConfigLoader, err = baseconfig.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var keyResolver *promtailconfig.KeyResolver
+	{
+		k8sOwnerGetter, err := promtailconfig.NewK8sOwnerGetter(k8sClient.K8sClient())
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		ownerGetter, err := promtailconfig.NewCachedOwnerGetter(promtailconfig.CachedOwnerGetterConfig{
+			OwnerGetter: k8sOwnerGetter,
+		})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		c := promtailconfig.KeyResolverConfig{
+			OwnerGetter: ownerGetter,
+
+			IgnoredLabels: config.Viper.GetStringSlice(config.Flag.Loki.IgnoredLabels),
+		}
+
+		keyResolver, err = promtailconfig.NewKeyResolver(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var podConfig *podconfig.Resolver
+	{
+		c := podconfig.Config{
+			K8sClient:     k8sClient.K8sClient(),
+			KeyResolver:   keyResolver,
+			LeaderElector: leaderElector,
+			Recorder:      eventRecorder,
+		}
+
+		podConfig, err = podconfig.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var snippetWatcher *snippetwatcher.Watcher
+	{
+		c := snippetwatcher.Config{
 			K8sClient: k8sClient.K8sClient(),
 			Logger:    config.Logger,
+			Handler:   promtailHandler,
+			PodConfig: podConfig,
+
+			LabelSelector: config.Viper.GetString(config.Flag.Loki.SnippetLabelSelector),
+			Namespaces:    namespaceScope.Namespaces(),
+		}
+
+		snippetWatcher, err = snippetwatcher.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var resyncer *resync.Resyncer
+	{
+		c := resync.Config{
+			K8sClient:         k8sClient,
+			Logger:            config.Logger,
+			Handler:           promtailHandler,
+			PodConfig:         podConfig,
+			PromtailConfigMap: promtailConfigMap,
+			Scope:             namespaceScope,
+			Watcher:           snippetWatcher,
+
+			Interval: time.Duration(config.Viper.GetInt(config.Flag.Loki.ResyncIntervalSec)) * time.Second,
+		}
+
+		resyncer, err = resync.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var podWatcher *podwatcher.Watcher
+	{
+		c := podwatcher.Config{
+			K8sClient:      k8sClient.K8sClient(),
+			Logger:         config.Logger,
+			Handler:        promtailHandler,
+			PodConfig:      podConfig,
+			Recorder:       eventRecorder,
+			Scope:          namespaceScope,
+			SnippetWatcher: snippetWatcher,
+		}
+
+		podWatcher, err = podwatcher.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var promtailConfigController *controller.PromtailConfig
+	{
+		c := controller.PromtailConfigConfig{
+			K8sClient: k8sClient,
+			Logger:    config.Logger,
+			Handler:   promtailHandler,
+			Scope:     namespaceScope,
+			Tracker:   promtailConfigTracker,
+		}
+
+		promtailConfigController, err = controller.NewPromtailConfig(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var operatorCollector *collector.Set
+	{
+		c := collector.SetConfig{
+			K8sClient:     k8sClient.K8sClient(),
+			LeaderElector: leaderElector,
+			Logger:        config.Logger,
 		}
 
 		operatorCollector, err = collector.NewSet(c)
@@ -142,9 +435,18 @@ func New(config Config) (*Service, error) {
 	s := &Service{
 		Version: versionService,
 
-		bootOnce:          sync.Once{},
-		todoController:    todoController,
-		operatorCollector: operatorCollector,
+		baseConfigLoader:         baseConfigLoader,
+		bootOnce:                 sync.Once{},
+		eventRecorder:            eventRecorder,
+		leaderElector:            leaderElector,
+		namespaceScope:           namespaceScope,
+		promtailHandler:          promtailHandler,
+		promtailReloader:         promtailReloader,
+		snippetWatcher:           snippetWatcher,
+		resyncer:                 resyncer,
+		podWatcher:               podWatcher,
+		promtailConfigController: promtailConfigController,
+		operatorCollector:        operatorCollector,
 	}
 
 	return s, nil
@@ -154,6 +456,31 @@ func (s *Service) Boot(ctx context.Context) {
 	s.bootOnce.Do(func() {
 		go s.operatorCollector.Boot(ctx)
 
-		go s.todoController.Boot(ctx)
+		go s.eventRecorder.Boot(ctx)
+		go s.baseConfigLoader.Boot(ctx)
+		go s.namespaceScope.Boot(ctx)
+		// Only the leader writes to the Kubernetes API: promtail's
+		// ConfigMap, the status and finalizers of PromtailConfigs and
+		// the removal of legacy finalizers. Events and the annotations
+		// of snippet ConfigMaps are dropped on the other replicas. The
+		// snippets are registered by all replicas, PromtailConfigs by
+		// the resync.
+		go func() {
+			err := s.leaderElector.Run(ctx, func(ctx context.Context) {
+				go s.promtailReloader.Boot(ctx)
+				go s.podWatcher.RemoveLegacyFinalizers(ctx)
+				go s.promtailConfigController.Boot(ctx)
+				s.promtailHandler.Boot(ctx)
+			})
+			if err != nil {
+				// Without the election nothing is ever written, so the
+				// operator must not keep running.
+				panic(microerror.Stack(err))
+			}
+		}()
+		go s.snippetWatcher.Boot(ctx)
+		go s.resyncer.Boot(ctx)
+
+		go s.podWatcher.Boot(ctx)
 	})
 }
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"github.com/giantswarm/microerror"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+// yamlValue returns the value of key as YAML. Values given on the command line
+// are YAML already, sections of the config file are marshalled.
+func yamlValue(v *viper.Viper, key string) (string, error) {
+	value := v.Get(key)
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	}
+
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return string(b), nil
+}